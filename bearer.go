@@ -0,0 +1,39 @@
+package cmhttp
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Bearer sets the Authorization header of all requests to a bearer token
+// obtained from src, unless the header is already non-empty. If src returns an
+// error without a token, the request is not sent and the error is returned. If
+// src returns a token together with an error, such as the previous token of a
+// FileToken whose file has become unreadable, the token is used.
+func Bearer(src TokenSource) Decorator {
+	return bearer(src, false)
+}
+
+// BearerOverwrite is like Bearer but replaces any Authorization header the
+// request already has.
+func BearerOverwrite(src TokenSource) Decorator {
+	return bearer(src, true)
+}
+
+func bearer(src TokenSource, overwrite bool) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if !overwrite && r.Header.Get("Authorization") != "" {
+				return c.Do(r)
+			}
+
+			token, err := src.Token(r.Context())
+			if err != nil && token.Value == "" {
+				return nil, fmt.Errorf("obtaining bearer token: %w", err)
+			}
+
+			r.Header.Set("Authorization", "Bearer "+token.Value)
+			return c.Do(r)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package cmhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBearer(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		decorator Decorator
+		header    string
+		want      string
+	}{
+		{Bearer(StaticToken("abc")), "", "Bearer abc"},
+		{Bearer(StaticToken("abc")), "Basic Zm9vOmJhcg==", "Basic Zm9vOmJhcg=="},
+		{BearerOverwrite(StaticToken("abc")), "Basic Zm9vOmJhcg==", "Bearer abc"},
+	}
+
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+
+		resp, err := c.decorator(http.DefaultClient).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if got != c.want {
+			t.Errorf("Authorization header = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	src := FileToken(path, 0)
+	assertToken(t, src, "first")
+
+	// Make sure the modification time changes even on file systems with
+	// coarse timestamps.
+	if err := os.WriteFile(path, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	assertToken(t, src, "second")
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	token, err := src.Token(context.Background())
+	if err == nil {
+		t.Error("Expected error for missing token file")
+	}
+	if token.Value != "second" {
+		t.Errorf("Token() = %q after error, want previous token %q", token.Value, "second")
+	}
+
+	var got string
+	client := Bearer(CachedToken(src, 0))(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatalf("Bearer failed although a previous token is available: %v", err)
+	}
+	if got != "Bearer second" {
+		t.Errorf("Authorization header = %q, want %q", got, "Bearer second")
+	}
+}
+
+func TestCachedToken(t *testing.T) {
+	var calls int
+	src := CachedToken(TokenSourceFunc(func(context.Context) (Token, error) {
+		calls++
+		return Token{Value: "t", Expiry: time.Now().Add(time.Minute)}, nil
+	}), 10*time.Second)
+
+	assertToken(t, src, "t")
+	assertToken(t, src, "t")
+	if calls != 1 {
+		t.Errorf("Underlying source was called %d times, want 1", calls)
+	}
+
+	src = CachedToken(TokenSourceFunc(func(context.Context) (Token, error) {
+		calls++
+		return Token{Value: "t", Expiry: time.Now().Add(5 * time.Second)}, nil
+	}), 10*time.Second)
+
+	calls = 0
+	assertToken(t, src, "t")
+	assertToken(t, src, "t")
+	if calls != 2 {
+		t.Errorf("Underlying source was called %d times for tokens within leeway, want 2", calls)
+	}
+}
+
+func assertToken(t *testing.T, src TokenSource, want string) {
+	t.Helper()
+
+	token, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Value != want {
+		t.Errorf("Token() = %q, want %q", token.Value, want)
+	}
+}
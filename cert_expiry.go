@@ -0,0 +1,185 @@
+package cmhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A CertExpiryMonitor records when the certificates of TLS connections and
+// client certificates expire. It is a prometheus.Collector that exposes the
+// gauge http_client_tls_certificate_expiry_seconds with the number of seconds
+// until each certificate expires, partitioned by the host the certificate was
+// seen for ("host" label), the certificate's subject ("subject" label) and
+// whether it is a server or client certificate ("role" label).
+//
+// Server certificates that have not been seen for StaleAfter are dropped
+// when the metrics are collected, so that hosts that are no longer contacted
+// and replaced certificates disappear from the metrics.
+//
+// Use TLSOptions.ExpiryMonitor to attach a monitor to a transport, and
+// register it with a prometheus.Registerer to expose the metrics:
+//
+//	m := cmhttp.NewCertExpiryMonitor(7*24*time.Hour, func(host string, cert *x509.Certificate) {
+//		log.Printf("certificate %s for %s expires at %s", cert.Subject, host, cert.NotAfter)
+//	})
+//	prometheus.MustRegister(m)
+type CertExpiryMonitor struct {
+	// StaleAfter is how long server certificates are exposed after they have
+	// last been seen. It defaults to 24 hours and must not be changed once the
+	// monitor is in use.
+	StaleAfter time.Duration
+
+	threshold  time.Duration
+	onExpiring func(host string, cert *x509.Certificate)
+	desc       *prometheus.Desc
+
+	mu          sync.Mutex
+	certs       map[certExpiryKey]certExpiry
+	clientCerts []*ClientCertificate
+	reported    map[certExpiryKey]time.Time
+}
+
+type certExpiryKey struct {
+	host    string
+	subject string
+	role    string
+}
+
+type certExpiry struct {
+	notAfter time.Time
+	lastSeen time.Time
+}
+
+// expiringCert is a certificate that onExpiring must be called for.
+type expiringCert struct {
+	host string
+	cert *x509.Certificate
+}
+
+// NewCertExpiryMonitor returns a new CertExpiryMonitor. If onExpiring is not
+// nil, it is called once for each certificate that is observed less than
+// threshold before it expires.
+func NewCertExpiryMonitor(threshold time.Duration, onExpiring func(host string, cert *x509.Certificate)) *CertExpiryMonitor {
+	return &CertExpiryMonitor{
+		StaleAfter: 24 * time.Hour,
+		threshold:  threshold,
+		onExpiring: onExpiring,
+		desc: prometheus.NewDesc(
+			"http_client_tls_certificate_expiry_seconds",
+			"The number of seconds until a TLS certificate expires.",
+			[]string{"host", "subject", "role"}, nil,
+		),
+		certs:    make(map[certExpiryKey]certExpiry),
+		reported: make(map[certExpiryKey]time.Time),
+	}
+}
+
+// ObservePeerCertificates records the expiry times of the certificates that
+// the server presented on a connection to host.
+func (m *CertExpiryMonitor) ObservePeerCertificates(host string, certs []*x509.Certificate) {
+	var expiring []expiringCert
+
+	m.mu.Lock()
+	for _, cert := range certs {
+		expiring = m.observe(expiring, certExpiryKey{host, cert.Subject.String(), "server"}, cert)
+	}
+	m.mu.Unlock()
+
+	m.report(expiring)
+}
+
+// ObserveClientCertificate records the expiry time of cert. The certificate
+// is checked again whenever the metrics are collected, so that reloaded
+// certificates are taken into account.
+func (m *CertExpiryMonitor) ObserveClientCertificate(cert *ClientCertificate) {
+	m.mu.Lock()
+	m.clientCerts = append(m.clientCerts, cert)
+	expiring := m.observeClientCertificate(nil, cert)
+	m.mu.Unlock()
+
+	m.report(expiring)
+}
+
+// observeClientCertificate records cert. m.mu must be held.
+func (m *CertExpiryMonitor) observeClientCertificate(expiring []expiringCert, cert *ClientCertificate) []expiringCert {
+	leaf := cert.Certificate().Leaf
+	return m.observe(expiring, certExpiryKey{"", leaf.Subject.String(), "client"}, leaf)
+}
+
+// observe records cert and appends it to expiring if onExpiring must be
+// called for it. m.mu must be held.
+func (m *CertExpiryMonitor) observe(expiring []expiringCert, key certExpiryKey, cert *x509.Certificate) []expiringCert {
+	m.certs[key] = certExpiry{notAfter: cert.NotAfter, lastSeen: time.Now()}
+
+	if m.onExpiring == nil || time.Until(cert.NotAfter) >= m.threshold {
+		return expiring
+	}
+	if notAfter, ok := m.reported[key]; ok && notAfter.Equal(cert.NotAfter) {
+		return expiring
+	}
+
+	m.reported[key] = cert.NotAfter
+	return append(expiring, expiringCert{key.host, cert})
+}
+
+// report calls onExpiring for each certificate in expiring. m.mu must not be
+// held, so that onExpiring can take its time without blocking TLS handshakes.
+func (m *CertExpiryMonitor) report(expiring []expiringCert) {
+	for _, e := range expiring {
+		m.onExpiring(e.host, e.cert)
+	}
+}
+
+// verifyConnection observes the peer certificates of cs. It never fails and is
+// meant to be used as tls.Config.VerifyConnection.
+func (m *CertExpiryMonitor) verifyConnection(cs tls.ConnectionState) error {
+	certs := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		certs = cs.VerifiedChains[0]
+	}
+
+	m.ObservePeerCertificates(cs.ServerName, certs)
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (m *CertExpiryMonitor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+// Collect implements prometheus.Collector.
+func (m *CertExpiryMonitor) Collect(ch chan<- prometheus.Metric) {
+	var expiring []expiringCert
+
+	m.mu.Lock()
+	for key, c := range m.certs {
+		switch {
+		case key.role == "client":
+			delete(m.certs, key)
+		case time.Since(c.lastSeen) > m.StaleAfter:
+			delete(m.certs, key)
+			delete(m.reported, key)
+		}
+	}
+	for _, cert := range m.clientCerts {
+		expiring = m.observeClientCertificate(expiring, cert)
+	}
+
+	metrics := make([]prometheus.Metric, 0, len(m.certs))
+	for key, c := range m.certs {
+		metrics = append(metrics, prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue,
+			time.Until(c.notAfter).Seconds(),
+			key.host, key.subject, key.role,
+		))
+	}
+	m.mu.Unlock()
+
+	for _, metric := range metrics {
+		ch <- metric
+	}
+	m.report(expiring)
+}
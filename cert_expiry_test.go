@@ -0,0 +1,137 @@
+package cmhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCertExpiryMonitor(t *testing.T) {
+	notAfter := time.Now().Add(48 * time.Hour)
+	certPEM, key := testCertificate(t, "example.com", notAfter)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	roots := filepath.Join(dir, "roots.pem")
+	writeFile(t, roots, certPEM)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestKeyPair(t, certFile, keyFile, "client")
+	clientCert, err := LoadClientCertificate(certFile, keyFile, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expiring []string
+	monitor := NewCertExpiryMonitor(7*24*time.Hour, func(host string, cert *x509.Certificate) {
+		expiring = append(expiring, host+" "+cert.Subject.CommonName)
+	})
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(monitor)
+
+	transport := &http.Transport{}
+	err = ConfigureTLSWithOptions(transport, TLSOptions{
+		RootCertsFile:     roots,
+		ServerName:        "example.com",
+		ClientCertificate: clientCert,
+		ExpiryMonitor:     monitor,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// The client certificate expires in an hour, the server certificate in
+	// two days; each must be reported once.
+	if len(expiring) != 2 || expiring[0] != " client" || expiring[1] != "example.com example.com" {
+		t.Errorf("Expiring certificates reported as %q", expiring)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || len(families[0].Metric) != 2 {
+		t.Fatalf("Unexpected metrics %v", families)
+	}
+
+	for _, m := range families[0].Metric {
+		labels := map[string]string{}
+		for _, l := range m.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+
+		got := time.Duration(m.Gauge.GetValue()) * time.Second
+		switch labels["role"] {
+		case "server":
+			if labels["host"] != "example.com" || got < 47*time.Hour || got > 48*time.Hour {
+				t.Errorf("Unexpected server certificate metric %v: %s", labels, got)
+			}
+		case "client":
+			if labels["subject"] != "CN=client" || got < 59*time.Minute || got > time.Hour {
+				t.Errorf("Unexpected client certificate metric %v: %s", labels, got)
+			}
+		default:
+			t.Errorf("Unexpected metric %v", labels)
+		}
+	}
+}
+
+func TestCertExpiryMonitor_DropsStaleCertificates(t *testing.T) {
+	monitor := NewCertExpiryMonitor(time.Hour, nil)
+	monitor.StaleAfter = time.Minute
+
+	cert := &x509.Certificate{NotAfter: time.Now().Add(48 * time.Hour)}
+	monitor.ObservePeerCertificates("old.example.com", []*x509.Certificate{cert})
+	monitor.ObservePeerCertificates("new.example.com", []*x509.Certificate{cert})
+
+	key := certExpiryKey{"old.example.com", cert.Subject.String(), "server"}
+	monitor.certs[key] = certExpiry{notAfter: cert.NotAfter, lastSeen: time.Now().Add(-2 * time.Minute)}
+
+	if n := testutil.CollectAndCount(monitor); n != 1 {
+		t.Errorf("Got %d metrics, want 1", n)
+	}
+	if _, ok := monitor.certs[key]; ok {
+		t.Error("Stale certificate has not been dropped")
+	}
+}
+
+func TestCertExpiryMonitor_ReportsWithoutLock(t *testing.T) {
+	var monitor *CertExpiryMonitor
+	monitor = NewCertExpiryMonitor(time.Hour, func(host string, cert *x509.Certificate) {
+		// Would deadlock if the monitor was still locked.
+		testutil.CollectAndCount(monitor)
+	})
+
+	cert := &x509.Certificate{NotAfter: time.Now().Add(time.Minute)}
+	monitor.ObservePeerCertificates("example.com", []*x509.Certificate{cert})
+}
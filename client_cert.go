@@ -0,0 +1,190 @@
+package cmhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// clientCertCheckInterval is how often a ClientCertificate checks whether its
+// files have changed. It is changed in tests.
+var clientCertCheckInterval = 10 * time.Second
+
+// A ClientCertificate is a TLS client certificate that is loaded from PEM
+// encoded files and reloaded when the files change on disk, so that rotated
+// certificates are picked up without restarting the process.
+//
+// The files are checked for changes at most every 10 seconds, when a TLS
+// handshake requires the certificate. Connections that have already been
+// established keep using the certificate they were established with.
+type ClientCertificate struct {
+	certFile    string
+	keyFile     string
+	keyPassword []byte
+	onError     func(error)
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+// LoadClientCertificate loads a certificate (chain) and the matching private
+// key from the given PEM files. If the private key is encrypted with a
+// password (legacy RFC 1423 encryption), keyPassword must be set.
+//
+// If reloading the files fails later on, for instance because only one of
+// them has been replaced yet, the previously loaded certificate is used and
+// onReloadError is called with the error, unless it is nil.
+func LoadClientCertificate(certFile, keyFile string, keyPassword []byte, onReloadError func(error)) (*ClientCertificate, error) {
+	c := &ClientCertificate{
+		certFile:    certFile,
+		keyFile:     keyFile,
+		keyPassword: keyPassword,
+		onError:     onReloadError,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ConfigureClientCertificate configures the given transport to present cert
+// to servers that request a client certificate. Other TLS settings of the
+// transport are preserved.
+func ConfigureClientCertificate(t *http.Transport, cert *ClientCertificate) {
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.GetClientCertificate = cert.GetClientCertificate
+}
+
+// Reload loads the certificate and key files unconditionally. If loading
+// fails, the previously loaded certificate is kept and the error is returned.
+func (c *ClientCertificate) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reload()
+}
+
+// Certificate returns the currently loaded certificate.
+func (c *ClientCertificate) Certificate() *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.cert
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate. It
+// returns the current certificate, after reloading it if the files have
+// changed.
+func (c *ClientCertificate) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) >= clientCertCheckInterval {
+		c.lastCheck = time.Now()
+		if c.changed() {
+			if err := c.reload(); err != nil && c.onError != nil {
+				c.onError(err)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+// changed reports whether the modification time of either file differs from
+// the one seen when the files were last loaded. c.mu must be held.
+func (c *ClientCertificate) changed() bool {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return true // let reload report the error
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return true
+	}
+
+	return !certInfo.ModTime().Equal(c.certMod) || !keyInfo.ModTime().Equal(c.keyMod)
+}
+
+// reload loads the files. c.mu must be held.
+func (c *ClientCertificate) reload() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	certPEM, err := os.ReadFile(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err = decryptPEMKey(keyPEM, c.keyPassword)
+	if err != nil {
+		return fmt.Errorf("decrypting %s: %w", c.keyFile, err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("loading client certificate from %s and %s: %w", c.certFile, c.keyFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("parsing client certificate %s: %w", c.certFile, err)
+		}
+	}
+
+	c.cert = &cert
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+
+	return nil
+}
+
+// decryptPEMKey returns keyPEM with its first private key decrypted, if it is
+// encrypted.
+func decryptPEMKey(keyPEM, password []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return keyPEM, nil // let tls.X509KeyPair report the error
+	}
+
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, fmt.Errorf("encrypted PKCS #8 keys are not supported")
+	}
+
+	// Legacy PEM encryption is deprecated because it is insecure, but it is
+	// still produced by common tools.
+	if !x509.IsEncryptedPEMBlock(block) {
+		return keyPEM, nil
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("private key is encrypted but no password was given")
+	}
+
+	der, err := x509.DecryptPEMBlock(block, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
@@ -0,0 +1,178 @@
+package cmhttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCertificate(t *testing.T) {
+	defer func(d time.Duration) { clientCertCheckInterval = d }(clientCertCheckInterval)
+	clientCertCheckInterval = 0
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestKeyPair(t, certFile, keyFile, "first")
+
+	var reloadErr error
+	cert, err := LoadClientCertificate(certFile, keyFile, nil, func(err error) { reloadErr = err })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	ConfigureClientCertificate(transport, cert)
+	client := &http.Client{Transport: transport}
+
+	send := func() {
+		t.Helper()
+		transport.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	send()
+	if got != "first" {
+		t.Errorf("Server saw client certificate %q, want %q", got, "first")
+	}
+
+	writeTestKeyPair(t, certFile, keyFile, "second")
+	send()
+	if got != "second" {
+		t.Errorf("Server saw client certificate %q after rotation, want %q", got, "second")
+	}
+
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, keyFile)
+	send()
+	if got != "second" {
+		t.Errorf("Server saw client certificate %q after failed reload, want %q", got, "second")
+	}
+	if reloadErr == nil {
+		t.Error("Reload error callback was not called")
+	}
+}
+
+func TestLoadClientCertificate_EncryptedKey(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	certPEM, key := testCertificate(t, "encrypted", time.Now().Add(time.Hour))
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("password"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, pem.EncodeToMemory(block))
+
+	if _, err := LoadClientCertificate(certFile, keyFile, nil, nil); err == nil {
+		t.Error("Expected error for encrypted key without password")
+	}
+
+	cert, err := LoadClientCertificate(certFile, keyFile, []byte("password"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := cert.Certificate().Leaf.Subject.CommonName; cn != "encrypted" {
+		t.Errorf("Loaded certificate for %q, want %q", cn, "encrypted")
+	}
+}
+
+// testCertificate returns a PEM encoded, self-signed certificate and its key.
+func testCertificate(t *testing.T, commonName string, notAfter time.Time) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key
+}
+
+func writeTestKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	certPEM, key := testCertificate(t, commonName, time.Now().Add(time.Hour))
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	touch(t, certFile)
+	touch(t, keyFile)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch changes the modification time of path to a unique value, so that
+// changes are detected even on file systems with coarse timestamps.
+func touch(t *testing.T, path string) {
+	t.Helper()
+
+	fi, err := os.Stat(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+
+	mod := time.Now()
+	if fi != nil && !mod.After(fi.ModTime()) {
+		mod = fi.ModTime().Add(time.Second)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,199 @@
+package cmhttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultCoalesceMaxBodySize is the response body size above which Coalesced
+// stops sharing responses between identical requests.
+const DefaultCoalesceMaxBodySize = 1 << 20
+
+// CoalesceKey is the default key function for Coalesced. It coalesces GET and
+// HEAD requests with identical URLs and Accept, Accept-Encoding,
+// Accept-Language, Authorization and Cookie headers. Tracing headers and the
+// User-Agent and X-Request-Id headers are ignored.
+//
+// Requests with any other header return an empty key and are never coalesced,
+// because the header may change the response, e.g. X-Tenant-Id, or carry
+// credentials, e.g. X-Api-Key, so that requests of different users could
+// otherwise share a response.
+func CoalesceKey(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+
+	var key strings.Builder
+	key.WriteString(r.Method + " " + r.URL.String())
+	for _, name := range coalesceKeyHeaders {
+		key.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ", "))
+	}
+
+	for name := range r.Header {
+		name = http.CanonicalHeaderKey(name)
+		if !coalesceIgnoredHeaders[name] && !slices.Contains(coalesceKeyHeaders, name) {
+			return ""
+		}
+	}
+
+	return key.String()
+}
+
+// coalesceKeyHeaders are the headers that are part of the key returned by
+// CoalesceKey.
+var coalesceKeyHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+
+// coalesceIgnoredHeaders are the headers that CoalesceKey ignores because they
+// do not change the response.
+var coalesceIgnoredHeaders = map[string]bool{
+	"User-Agent":           true,
+	DefaultRequestIDHeader: true,
+	"Traceparent":          true,
+	"Tracestate":           true,
+	"Baggage":              true,
+}
+
+// Coalesced lets only one of several concurrent, identical requests hit the
+// network. Requests are considered identical if key returns the same non-empty
+// string for them; requests with an empty key are never coalesced. If key is
+// nil, CoalesceKey is used.
+//
+// Custom key functions must include everything that may make the responses to
+// two requests differ, in particular all headers that identify the user, such
+// as cookies and API keys. Otherwise users may receive responses that were
+// meant for others.
+//
+// Requests that arrive while an identical request is in flight wait for it to
+// complete, and each of them receives its own copy of the response, including
+// headers and a body that can be read independently of the other copies.
+//
+// Response bodies are buffered in memory to make this possible. If a body is
+// larger than maxBodySize bytes, the response is returned to the request that
+// made it unchanged, and all waiting requests are sent on their own instead.
+// If maxBodySize is zero or negative, DefaultCoalesceMaxBodySize is used.
+//
+// Note that all waiting requests share the outcome of the request in flight,
+// including errors caused by cancelling its context. Waiting requests stop
+// waiting and fail when their own context is done.
+func Coalesced(key func(*http.Request) string, maxBodySize int64) Decorator {
+	if key == nil {
+		key = CoalesceKey
+	}
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultCoalesceMaxBodySize
+	}
+
+	var (
+		mu     sync.Mutex
+		flying = make(map[string]*coalescedCall)
+	)
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			k := key(r)
+			if k == "" {
+				return c.Do(r)
+			}
+
+			mu.Lock()
+			if call, ok := flying[k]; ok {
+				mu.Unlock()
+
+				select {
+				case <-call.done:
+				case <-r.Context().Done():
+					return nil, r.Context().Err()
+				}
+
+				if call.skipped {
+					return c.Do(r)
+				}
+				return call.response(r)
+			}
+
+			call := &coalescedCall{done: make(chan struct{}), err: errCoalescedPanic}
+			flying[k] = call
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				delete(flying, k)
+				mu.Unlock()
+				close(call.done)
+			}()
+
+			return call.do(c, r, maxBodySize)
+		})
+	}
+}
+
+// errCoalescedPanic is returned to waiting requests if the request in flight
+// panicked.
+var errCoalescedPanic = errors.New("cmhttp: coalesced request panicked")
+
+// coalescedCall is a request in flight whose outcome is shared with all
+// identical requests that arrive before it completes.
+type coalescedCall struct {
+	done chan struct{}
+
+	resp    *http.Response
+	body    []byte
+	err     error
+	skipped bool
+}
+
+// do sends r and buffers the response body so that it can be shared. If the
+// body exceeds maxBodySize, the call is marked as skipped and the original
+// response is returned with its body intact. call.err is only cleared once a
+// response has been shared successfully.
+func (call *coalescedCall) do(c Client, r *http.Request, maxBodySize int64) (*http.Response, error) {
+	resp, err := c.Do(r)
+	if err != nil {
+		call.err = err
+		return resp, err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		call.err = err
+		return nil, err
+	}
+
+	if int64(len(body)) > maxBodySize {
+		call.skipped = true
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+
+	resp.Body.Close()
+	call.resp = resp
+	call.body = body
+	call.err = nil
+
+	return call.response(r)
+}
+
+// response returns an independent copy of the shared response for r.
+func (call *coalescedCall) response(r *http.Request) (*http.Response, error) {
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	resp := new(http.Response)
+	*resp = *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Trailer = call.resp.Trailer.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(call.body))
+	resp.Request = r
+
+	return resp, nil
+}
@@ -0,0 +1,241 @@
+package cmhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesced(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("X-Test", "yes")
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+
+	client := Coalesced(nil, 0)(http.DefaultClient)
+
+	n := 10
+	bodies := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if resp.Header.Get("X-Test") != "yes" {
+				t.Errorf("Response %d is missing the X-Test header", i)
+			}
+			resp.Header.Set("X-Test", "modified")
+
+			b, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(b)
+		}(i)
+	}
+	wg.Wait()
+
+	if hits := atomic.LoadInt32(&hits); hits != 1 {
+		t.Errorf("Server received %d requests, want 1", hits)
+	}
+	for i, b := range bodies {
+		if b != "hello" {
+			t.Errorf("Response %d has body %q, want %q", i, b, "hello")
+		}
+	}
+}
+
+func TestCoalesced_SkipsLargeBodies(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer server.Close()
+
+	client := Coalesced(nil, 10)(http.DefaultClient)
+
+	n := 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+
+			b, _ := io.ReadAll(resp.Body)
+			if len(b) != 100 {
+				t.Errorf("Response body has %d bytes, want 100", len(b))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if hits := atomic.LoadInt32(&hits); hits != int32(n) {
+		t.Errorf("Server received %d requests, want %d", hits, n)
+	}
+}
+
+func TestCoalesced_EmptyKey(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := Coalesced(nil, 0)(http.DefaultClient)
+
+	n := 3
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("POST", server.URL, strings.NewReader("{}"))
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if hits := atomic.LoadInt32(&hits); hits != int32(n) {
+		t.Errorf("Server received %d requests, want %d", hits, n)
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	newRequest := func(header ...string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Add(header[i], header[i+1])
+		}
+		return req
+	}
+
+	alice := CoalesceKey(newRequest("Cookie", "session=alice"))
+	bob := CoalesceKey(newRequest("Cookie", "session=bob"))
+	if alice == "" || alice == bob {
+		t.Errorf("Requests with different cookies have keys %q and %q", alice, bob)
+	}
+
+	en := CoalesceKey(newRequest("Accept-Language", "en"))
+	de := CoalesceKey(newRequest("Accept-Language", "de"))
+	if en == "" || en == de {
+		t.Errorf("Requests with different languages have keys %q and %q", en, de)
+	}
+
+	for _, name := range []string{"X-Api-Key", "Proxy-Authorization", "X-Auth-Token", "X-Session-Id", "X-Tenant-Id"} {
+		if key := CoalesceKey(newRequest(name, "secret")); key != "" {
+			t.Errorf("Request with %s header has key %q, want none", name, key)
+		}
+	}
+
+	first := CoalesceKey(newRequest("X-Request-Id", "1", "User-Agent", "a"))
+	second := CoalesceKey(newRequest("X-Request-Id", "2", "User-Agent", "b"))
+	if first == "" || first != second {
+		t.Errorf("Requests that differ in ignored headers have keys %q and %q", first, second)
+	}
+}
+
+func TestCoalesced_WaitersRespectContext(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		close(started)
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	client := Coalesced(nil, 0)(slow)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		client.Do(req)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+
+	begin := time.Now()
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if took := time.Since(begin); took > time.Second {
+		t.Errorf("Waiting request returned after %s", took)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestCoalesced_Panic(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	panicking := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		once.Do(func() { close(started) })
+		<-release
+		panic("boom")
+	})
+	client := Coalesced(nil, 0)(panicking)
+
+	go func() {
+		defer func() { recover() }()
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		client.Do(req)
+	}()
+	<-started
+
+	result := make(chan error)
+	go func() {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		_, err := client.Do(req)
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond) // let the second request start waiting
+	close(release)
+
+	select {
+	case err := <-result:
+		if err != errCoalescedPanic {
+			t.Errorf("Got error %v, want %v", err, errCoalescedPanic)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Waiting request is still blocked after the first request panicked")
+	}
+}
@@ -0,0 +1,93 @@
+package cmhttp
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultRequestIDHeader is the request ID header used by Correlated and
+// CorrelationFromRequest if no other header is given.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+type correlationKey struct{}
+
+// Correlation holds the values that are forwarded by Correlated to correlate
+// requests across services without a tracing SDK.
+type Correlation struct {
+	TraceParent string // W3C traceparent header
+	TraceState  string // W3C tracestate header
+	Baggage     string // W3C baggage header
+	RequestID   string
+}
+
+// WithCorrelation returns a copy of ctx that carries c.
+func WithCorrelation(ctx context.Context, c Correlation) context.Context {
+	return context.WithValue(ctx, correlationKey{}, c)
+}
+
+// CorrelationFromContext returns the Correlation stored in ctx by
+// WithCorrelation, or the zero value if there is none.
+func CorrelationFromContext(ctx context.Context) Correlation {
+	c, _ := ctx.Value(correlationKey{}).(Correlation)
+	return c
+}
+
+// CorrelationFromRequest returns the correlation headers of an incoming
+// server request. If requestIDHeader is empty, DefaultRequestIDHeader is used.
+//
+// It is usually combined with WithCorrelation in a server handler:
+//
+//	ctx := cmhttp.WithCorrelation(r.Context(), cmhttp.CorrelationFromRequest(r, ""))
+func CorrelationFromRequest(r *http.Request, requestIDHeader string) Correlation {
+	if requestIDHeader == "" {
+		requestIDHeader = DefaultRequestIDHeader
+	}
+
+	return Correlation{
+		TraceParent: r.Header.Get("Traceparent"),
+		TraceState:  r.Header.Get("Tracestate"),
+		Baggage:     r.Header.Get("Baggage"),
+		RequestID:   r.Header.Get(requestIDHeader),
+	}
+}
+
+// Correlated sets the traceparent, tracestate, baggage and request ID headers
+// of all requests from the Correlation in the request's context, unless the
+// respective header is non-empty. If requestIDHeader is empty,
+// DefaultRequestIDHeader is used.
+//
+// If the context has no request ID, the request ID header is used, or a random
+// request ID is generated if the header is empty. It is stored in the context
+// of the request that is passed on, so that decorated clients can pick it up
+// with CorrelationFromContext.
+func Correlated(requestIDHeader string) Decorator {
+	if requestIDHeader == "" {
+		requestIDHeader = DefaultRequestIDHeader
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			corr := CorrelationFromContext(r.Context())
+			if corr.RequestID == "" {
+				corr.RequestID = r.Header.Get(requestIDHeader)
+				if corr.RequestID == "" {
+					corr.RequestID = randomHex(16)
+				}
+				r = r.WithContext(WithCorrelation(r.Context(), corr))
+			}
+
+			for name, value := range map[string]string{
+				"Traceparent":   corr.TraceParent,
+				"Tracestate":    corr.TraceState,
+				"Baggage":       corr.Baggage,
+				requestIDHeader: corr.RequestID,
+			} {
+				if value != "" && r.Header.Get(name) == "" {
+					r.Header.Set(name, value)
+				}
+			}
+
+			return c.Do(r)
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package cmhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorrelated(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	client := Correlated("X-Correlation-Id")(http.DefaultClient)
+
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithCorrelation(r.Context(), CorrelationFromRequest(r, "X-Correlation-Id"))
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", backend.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}))
+	defer frontend.Close()
+
+	req, _ := http.NewRequest("GET", frontend.URL, nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "congo=t61rcWkgMzE")
+	req.Header.Set("Baggage", "userId=alice")
+	req.Header.Set("X-Correlation-Id", "abc")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for _, name := range []string{"Traceparent", "Tracestate", "Baggage", "X-Correlation-Id"} {
+		if got, want := received.Get(name), req.Header.Get(name); got != want {
+			t.Errorf("Backend received %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCorrelated_GeneratesRequestID(t *testing.T) {
+	var requestID string
+	client := Correlated("")(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if got := CorrelationFromContext(r.Context()).RequestID; got != r.Header.Get(DefaultRequestIDHeader) {
+			t.Errorf("Request ID in context %q differs from header %q", got, r.Header.Get(DefaultRequestIDHeader))
+		}
+		requestID = r.Header.Get(DefaultRequestIDHeader)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(requestID) != 32 {
+		t.Errorf("Generated request ID %q, want 32 hex characters", requestID)
+	}
+}
@@ -0,0 +1,217 @@
+package cmhttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxInlineCurlBodySize is the size up to which request bodies are
+// included in curl commands directly if no other limit is given.
+const DefaultMaxInlineCurlBodySize = 4 << 10
+
+// CurlOptions configures ToCurlWithOptions and CurlLogged.
+type CurlOptions struct {
+	// MaxInlineBodySize is the size up to which request bodies are included
+	// in the command with --data-raw. Larger and binary bodies are written to
+	// a file in BodyDir, which is referenced with --data-binary @file. If
+	// zero, DefaultMaxInlineCurlBodySize is used.
+	MaxInlineBodySize int64
+
+	// BodyDir is the directory that large and binary bodies are written to.
+	// The files are not redacted and never removed; that is up to the caller.
+	// If empty, such bodies are left out and the command contains a summary
+	// of the body instead.
+	BodyDir string
+
+	// Redaction determines which headers and query parameters are secret.
+	// Secret headers are replaced by references to environment variables
+	// named after the header, e.g. $AUTHORIZATION, unless RedactSecrets is
+	// true, in which case their values are replaced with the policy's
+	// replacement. Secret query parameters are only replaced if RedactSecrets
+	// is true.
+	Redaction     RedactionPolicy
+	RedactSecrets bool
+}
+
+// ToCurl returns a curl command that makes the request r, using the default
+// CurlOptions, except that large and binary bodies are written to
+// os.TempDir(). See ToCurlWithOptions.
+func ToCurl(r *http.Request) (string, error) {
+	return ToCurlWithOptions(r, CurlOptions{BodyDir: os.TempDir()})
+}
+
+// ToCurlWithOptions returns a curl command that makes the request r, quoted
+// for POSIX shells. Passwords in the URL are always redacted.
+//
+// The request body is obtained with r.GetBody if possible. Otherwise it is
+// read and replaced with an equivalent body, and r.GetBody is set.
+func ToCurlWithOptions(r *http.Request, opts CurlOptions) (string, error) {
+	if opts.MaxInlineBodySize <= 0 {
+		opts.MaxInlineBodySize = DefaultMaxInlineCurlBodySize
+	}
+	body, err := requestBody(r)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"curl"}
+
+	switch r.ProtoMajor*10 + r.ProtoMinor {
+	case 10:
+		args = append(args, "--http1.0")
+	case 20:
+		args = append(args, "--http2")
+	}
+
+	switch r.Method {
+	case "", http.MethodGet:
+		if body != nil {
+			args = append(args, "-X", "GET")
+		}
+	case http.MethodHead:
+		args = append(args, "--head")
+	case http.MethodPost:
+		if body == nil {
+			args = append(args, "-X", "POST")
+		}
+	default:
+		args = append(args, "-X", shellQuote(r.Method))
+	}
+
+	if r.Host != "" && r.Host != r.URL.Host {
+		args = append(args, "-H", shellQuote("Host: "+r.Host))
+	}
+
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			switch {
+			case !opts.Redaction.RedactsHeader(name):
+				args = append(args, "-H", shellQuote(name+": "+value))
+			case opts.RedactSecrets:
+				args = append(args, "-H", shellQuote(name+": "+opts.Redaction.replacement()))
+			default:
+				args = append(args, "-H", shellQuote(name+": ")+`"$`+envVarName(name)+`"`)
+			}
+		}
+	}
+
+	if body != nil {
+		switch {
+		case int64(len(body)) <= opts.MaxInlineBodySize && utf8.Valid(body) && bytes.IndexByte(body, 0) < 0:
+			// --data-raw, unlike --data-binary, doesn't read a file if the
+			// body starts with @.
+			args = append(args, "--data-raw", shellQuote(string(body)))
+		case opts.BodyDir == "":
+			args = append(args, "--data-raw", shellQuote(fmt.Sprintf("[%d byte body omitted]", len(body))))
+		default:
+			f, err := os.CreateTemp(opts.BodyDir, "cmhttp-curl-*.body")
+			if err != nil {
+				return "", err
+			}
+			_, err = f.Write(body)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return "", err
+			}
+			args = append(args, "--data-binary", shellQuote("@"+f.Name()))
+		}
+	}
+
+	if opts.RedactSecrets {
+		args = append(args, shellQuote(opts.Redaction.RedactURL(r.URL)))
+	} else {
+		args = append(args, shellQuote(r.URL.Redacted()))
+	}
+
+	return strings.Join(args, " "), nil
+}
+
+// CurlLogged logs every request as curl command to logger at LevelDebug,
+// before it is made. If logger is nil, slog.Default() is used. Requests whose
+// command cannot be created are logged with the error instead. Large and
+// binary bodies are only written to files if opts.BodyDir is set, see
+// CurlOptions.
+func CurlLogged(logger *slog.Logger, opts CurlOptions) Decorator {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			ctx := r.Context()
+			if !logger.Enabled(ctx, slog.LevelDebug) {
+				return c.Do(r)
+			}
+
+			cmd, err := ToCurlWithOptions(r, opts)
+			if err != nil {
+				logger.LogAttrs(ctx, slog.LevelDebug, "cmhttp curl command",
+					slog.String("url", opts.Redaction.RedactURL(r.URL)),
+					slog.String("error", err.Error()),
+				)
+			} else {
+				logger.LogAttrs(ctx, slog.LevelDebug, "cmhttp curl command", slog.String("curl", cmd))
+			}
+
+			return c.Do(r)
+		})
+	}
+}
+
+// requestBody returns the body of r, or nil if it has none, without consuming
+// r.Body.
+func requestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody == nil {
+		return readAndRestoreBody(r)
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@=,+%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// envVarName returns the name of the environment variable that stands in for
+// the value of the header with the given name, e.g. X_API_KEY for X-Api-Key.
+func envVarName(header string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, header)
+}
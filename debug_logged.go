@@ -0,0 +1,193 @@
+package cmhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxLoggedBodySize is the number of body bytes logged by DebugLogged
+// if no other limit is given.
+const DefaultMaxLoggedBodySize = 64 << 10
+
+// DebugLogOptions configures DebugLogged.
+type DebugLogOptions struct {
+	// MaxBodySize is the maximum number of bytes of each body that are
+	// logged. If zero, DefaultMaxLoggedBodySize is used.
+	MaxBodySize int64
+
+	// Redaction is applied to headers and JSON bodies before they are logged.
+	Redaction RedactionPolicy
+}
+
+// DebugLogged logs the headers and bodies of every request and its response
+// to logger at LevelDebug, for debugging. If logger is nil, slog.Default() is
+// used.
+//
+// Up to opts.MaxBodySize bytes of each body are read into a buffer before the
+// request is sent and before the response is returned, and the bodies are
+// restored so that they can still be read completely. JSON bodies are pretty
+// printed, text bodies are logged as is and binary bodies are summarized.
+//
+// URLs, headers and JSON bodies are redacted with opts.Redaction. If the policy
+// redacts JSON fields, all text bodies are checked for JSON regardless of
+// their content type, and bodies that look like JSON but cannot be parsed,
+// for instance because they were truncated, are not logged.
+func DebugLogged(logger *slog.Logger, opts DebugLogOptions) Decorator {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxLoggedBodySize
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			ctx := r.Context()
+			if !logger.Enabled(ctx, slog.LevelDebug) {
+				return c.Do(r)
+			}
+
+			var reqBody []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				var err error
+				r2 := *r
+				reqBody, r2.Body, err = peekBody(r.Body, opts.MaxBodySize)
+				if err != nil {
+					return nil, err
+				}
+				r = &r2
+			}
+
+			method := r.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", method),
+				slog.String("url", opts.Redaction.RedactURL(r.URL)),
+				slog.Group("request",
+					headerAttr(opts.Redaction.RedactHeader(r.Header)),
+					slog.String("body", formatBody(reqBody, r.ContentLength, r.Header.Get("Content-Type"), opts)),
+				),
+			}
+
+			resp, err := c.Do(r)
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+				logger.LogAttrs(ctx, slog.LevelDebug, "cmhttp client exchange", attrs...)
+				return resp, err
+			}
+
+			var respBody []byte
+			respBody, resp.Body, err = peekBody(resp.Body, opts.MaxBodySize)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+
+			attrs = append(attrs, slog.Group("response",
+				slog.Int("status", resp.StatusCode),
+				headerAttr(opts.Redaction.RedactHeader(resp.Header)),
+				slog.String("body", formatBody(respBody, resp.ContentLength, resp.Header.Get("Content-Type"), opts)),
+			))
+			logger.LogAttrs(ctx, slog.LevelDebug, "cmhttp client exchange", attrs...)
+
+			return resp, nil
+		})
+	}
+}
+
+// peekBody reads up to limit bytes from body and returns them together with a
+// body that yields all of body's data, including the bytes already read.
+func peekBody(body io.ReadCloser, limit int64) ([]byte, io.ReadCloser, error) {
+	buf, err := io.ReadAll(io.LimitReader(body, limit))
+	if err != nil {
+		return nil, body, err
+	}
+
+	return buf, struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), body), body}, nil
+}
+
+func headerAttr(h http.Header) slog.Attr {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]any, len(names))
+	for i, name := range names {
+		attrs[i] = slog.String(name, strings.Join(h[name], ", "))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+// formatBody returns the loggable representation of the first bytes of a body
+// with the given total size (-1 if unknown) and content type.
+func formatBody(body []byte, size int64, contentType string, opts DebugLogOptions) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	truncated := int64(len(body)) >= opts.MaxBodySize && size != int64(len(body))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	if !isTextContentType(contentType) {
+		if size < 0 {
+			return fmt.Sprintf("[binary %s body]", contentType)
+		}
+		return fmt.Sprintf("[binary %s body, %d bytes]", contentType, size)
+	}
+
+	doc, isJSON, ok := opts.Redaction.redactTextBody(body, contentType)
+	if !ok {
+		return fmt.Sprintf("[%s body omitted: %d bytes of invalid or truncated JSON cannot be redacted]", contentType, len(body))
+	}
+	if isJSON {
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, doc, "", "  ") == nil {
+			doc = pretty.Bytes()
+		}
+	}
+	return withTruncationNote(string(doc), truncated)
+}
+
+func withTruncationNote(body string, truncated bool) string {
+	if truncated {
+		return body + "\n[truncated]"
+	}
+	return body
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func isTextContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		isJSONContentType(mediaType),
+		mediaType == "application/xml",
+		strings.HasSuffix(mediaType, "+xml"),
+		mediaType == "application/x-www-form-urlencoded",
+		mediaType == "application/javascript":
+		return true
+	default:
+		return false
+	}
+}
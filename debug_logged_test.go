@@ -0,0 +1,182 @@
+package cmhttp
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDebugLogged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"user":"alice","password":"hunter2"}` {
+			t.Errorf("Server received body %q", body)
+		}
+
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte{0x89}, 100))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Write([]byte(`{"token":"abc","items":[1,2,3]}`))
+		}
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := DebugLogged(logger, DebugLogOptions{
+		Redaction: RedactionPolicy{
+			JSONPaths:  []string{"password"},
+			JSONFields: regexp.MustCompile(`^token$`),
+		},
+	})(http.DefaultClient)
+
+	for _, path := range []string{"/json", "/image"} {
+		req, _ := http.NewRequest("POST", server.URL+path, strings.NewReader(`{"user":"alice","password":"hunter2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if path == "/json" && string(body) != `{"token":"abc","items":[1,2,3]}` {
+			t.Errorf("Client received body %q", body)
+		}
+		if path == "/image" && len(body) != 100 {
+			t.Errorf("Client received %d bytes, want 100", len(body))
+		}
+	}
+
+	logs := buf.String()
+	for _, secret := range []string{"hunter2", "Bearer secret", "session=secret", "abc"} {
+		if strings.Contains(logs, secret) {
+			t.Errorf("Logs contain secret %q:\n%s", secret, logs)
+		}
+	}
+
+	for _, want := range []string{
+		`request.headers.Authorization=REDACTED`,
+		`response.headers.Set-Cookie=REDACTED`,
+		`\"user\": \"alice\"`,
+		`\"token\": \"REDACTED\"`,
+		`response.body="[binary image/png body, 100 bytes]"`,
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Logs do not contain %s:\n%s", want, logs)
+		}
+	}
+}
+
+func TestDebugLogged_TruncatedJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"password":"hunter2","padding":"` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := DebugLogged(logger, DebugLogOptions{
+		MaxBodySize: 32,
+		Redaction:   RedactionPolicy{JSONPaths: []string{"password"}},
+	})(http.DefaultClient)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if len(body) != 135 {
+		t.Errorf("Client received %d bytes, want 135", len(body))
+	}
+
+	logs := buf.String()
+	if strings.Contains(logs, "hunter2") {
+		t.Errorf("Logs contain secret:\n%s", logs)
+	}
+	if !strings.Contains(logs, "cannot be redacted") {
+		t.Errorf("Logs do not explain the omitted body:\n%s", logs)
+	}
+}
+
+func TestDebugLogged_UnlabeledJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := DebugLogged(logger, DebugLogOptions{
+		MaxBodySize: 64,
+		Redaction:   RedactionPolicy{JSONFields: regexp.MustCompile(`^password$`)},
+	})(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		io.Copy(io.Discard, r.Body)
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: http.NoBody}, nil
+	}))
+
+	for _, tt := range []struct {
+		contentType string
+		body        string
+	}{
+		{"", `{"password":"hunter2"}`},
+		{"text/plain", `{"password":"hunter2"}`},
+		{"text/plain", `{"password":"hunter2","padding":"` + strings.Repeat("x", 100) + `"}`},
+	} {
+		req, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if _, err := client.Do(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logs := buf.String()
+	if strings.Contains(logs, "hunter2") {
+		t.Errorf("Logs contain secret:\n%s", logs)
+	}
+	if n := strings.Count(logs, `\"password\": \"REDACTED\"`); n != 2 {
+		t.Errorf("Logs contain %d redacted bodies, want 2:\n%s", n, logs)
+	}
+	if !strings.Contains(logs, "cannot be redacted") {
+		t.Errorf("Logs do not explain the omitted body:\n%s", logs)
+	}
+}
+
+func TestDebugLogged_FormBody(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	client := DebugLogged(logger, DebugLogOptions{})(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		io.Copy(io.Discard, r.Body)
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: http.NoBody}, nil
+	}))
+
+	req, _ := http.NewRequest("POST", "http://example.com/token", strings.NewReader("grant_type=password&password=hunter2&client_secret=xyz"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := buf.String()
+	if strings.Contains(logs, "hunter2") || strings.Contains(logs, "xyz") {
+		t.Errorf("Logs contain secrets:\n%s", logs)
+	}
+	if !strings.Contains(logs, "grant_type=password&password=REDACTED&client_secret=REDACTED") {
+		t.Errorf("Logs do not contain the redacted form:\n%s", logs)
+	}
+}
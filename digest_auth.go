@@ -0,0 +1,270 @@
+package cmhttp
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DigestAuth authenticates all requests with HTTP Digest Authentication (RFC
+// 7616) using the provided username and password. The MD5 and SHA-256
+// algorithms, their session variants and the quality of protection values
+// "auth" and "auth-int" are supported.
+//
+// The first request is sent without credentials. If the server answers with a
+// Digest challenge, the request is sent again with an Authorization header.
+// The challenge is cached, so that subsequent requests are authenticated
+// immediately, with an incrementing nonce count. If the server signals that
+// the nonce is stale, the request is retried with the new challenge.
+//
+// Request bodies are buffered in memory if Request.GetBody is not set, so that
+// they can be sent again after a challenge.
+func DigestAuth(username, password string) Decorator {
+	return func(c Client) Client {
+		var (
+			mu        sync.Mutex
+			challenge *digestChallenge
+			nc        int
+		)
+
+		authorize := func(r *http.Request, body []byte) error {
+			mu.Lock()
+			ch := challenge
+			if ch == nil {
+				mu.Unlock()
+				return nil
+			}
+			nc++
+			count := nc
+			mu.Unlock()
+
+			auth, err := ch.authorization(r, username, password, count, randomHex(16), body)
+			if err != nil {
+				return err
+			}
+			r.Header.Set("Authorization", auth)
+			return nil
+		}
+
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := replayableBody(r)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := authorize(r, body); err != nil {
+				return nil, err
+			}
+			authorized := r.Header.Get("Authorization") != ""
+
+			resp, err := c.Do(r)
+			if err != nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+
+			ch := parseDigestChallenges(resp.Header.Values("WWW-Authenticate"))
+			if ch == nil || (authorized && !ch.stale) || !rewindBody(r) {
+				return resp, err
+			}
+			DrainClose(resp.Body)
+
+			mu.Lock()
+			challenge, nc = ch, 0
+			mu.Unlock()
+
+			if err := authorize(r, body); err != nil {
+				return nil, err
+			}
+
+			return c.Do(r)
+		})
+	}
+}
+
+// replayableBody makes sure the body of r can be sent again and returns it if
+// it had to be buffered. Bodies that can be replayed with Request.GetBody are
+// only read if their digest is required, which is done by bodyForDigest.
+func replayableBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.GetBody != nil {
+		return nil, nil
+	}
+	return readAndRestoreBody(r)
+}
+
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string // the selected qop, empty for RFC 2069 compatibility
+	stale     bool
+}
+
+// digestAlgorithms maps the supported algorithms to their hash functions, in
+// order of preference.
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SHA-256", sha256.New},
+	{"SHA-256-SESS", sha256.New},
+	{"MD5", md5.New},
+	{"MD5-SESS", md5.New},
+}
+
+// parseDigestChallenges returns the Digest challenge with the most preferred
+// algorithm from the given WWW-Authenticate header values, or nil if there is
+// no supported challenge.
+func parseDigestChallenges(headers []string) *digestChallenge {
+	var best *digestChallenge
+	bestRank := len(digestAlgorithms)
+
+	for _, h := range headers {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+
+		params := parseAuthParams(rest)
+		ch := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+			stale:     strings.EqualFold(params["stale"], "true"),
+		}
+		if ch.algorithm == "" {
+			ch.algorithm = "MD5"
+		}
+
+		if qop, ok := params["qop"]; ok {
+			for _, q := range strings.Split(qop, ",") {
+				q = strings.TrimSpace(q)
+				if q == "auth" || (q == "auth-int" && ch.qop == "") {
+					ch.qop = q
+				}
+			}
+			if ch.qop == "" {
+				continue
+			}
+		}
+
+		for rank, alg := range digestAlgorithms {
+			if strings.EqualFold(alg.name, ch.algorithm) && rank < bestRank {
+				best, bestRank = ch, rank
+			}
+		}
+	}
+
+	return best
+}
+
+// parseAuthParams parses a comma separated list of auth-params as defined in
+// RFC 7235, section 2.1. Parameter names are lower-cased.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			if i < len(rest) {
+				i++ // skip the closing quote
+			}
+			s = rest[i:]
+		} else {
+			v, tail, _ := strings.Cut(rest, ",")
+			value.WriteString(strings.TrimSpace(v))
+			s = tail
+		}
+
+		params[name] = value.String()
+	}
+}
+
+// authorization returns the Authorization header value for r.
+func (ch *digestChallenge) authorization(r *http.Request, username, password string, nc int, cnonce string, body []byte) (string, error) {
+	var newHash func() hash.Hash
+	for _, alg := range digestAlgorithms {
+		if strings.EqualFold(alg.name, ch.algorithm) {
+			newHash = alg.hash
+		}
+	}
+	h := func(s string) string {
+		d := newHash()
+		d.Write([]byte(s))
+		return fmt.Sprintf("%x", d.Sum(nil))
+	}
+
+	uri := r.URL.RequestURI()
+	ncValue := fmt.Sprintf("%08x", nc)
+
+	ha1 := h(username + ":" + ch.realm + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(ch.algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + ch.nonce + ":" + cnonce)
+	}
+
+	ha2 := h(r.Method + ":" + uri)
+	if ch.qop == "auth-int" {
+		body, err := bodyForDigest(r, body)
+		if err != nil {
+			return "", err
+		}
+		ha2 = h(r.Method + ":" + uri + ":" + h(string(body)))
+	}
+
+	var response string
+	if ch.qop == "" {
+		response = h(ha1 + ":" + ch.nonce + ":" + ha2)
+	} else {
+		response = h(strings.Join([]string{ha1, ch.nonce, ncValue, cnonce, ch.qop, ha2}, ":"))
+	}
+
+	fields := []string{
+		fmt.Sprintf("username=%q", username),
+		fmt.Sprintf("realm=%q", ch.realm),
+		fmt.Sprintf("uri=%q", uri),
+		fmt.Sprintf("algorithm=%s", ch.algorithm),
+		fmt.Sprintf("nonce=%q", ch.nonce),
+	}
+	if ch.qop != "" {
+		fields = append(fields,
+			fmt.Sprintf("nc=%s", ncValue),
+			fmt.Sprintf("cnonce=%q", cnonce),
+			fmt.Sprintf("qop=%s", ch.qop),
+		)
+	}
+	fields = append(fields, fmt.Sprintf("response=%q", response))
+	if ch.opaque != "" {
+		fields = append(fields, fmt.Sprintf("opaque=%q", ch.opaque))
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// bodyForDigest returns the body of r. buffered is the body if it has already
+// been read by replayableBody.
+func bodyForDigest(r *http.Request, buffered []byte) ([]byte, error) {
+	if buffered != nil || r.Body == nil || r.Body == http.NoBody {
+		return buffered, nil
+	}
+	return readAndRestoreBody(r)
+}
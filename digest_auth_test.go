@@ -0,0 +1,152 @@
+package cmhttp
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestDigestAuth_RFC7616 checks the examples in RFC 7616, section 3.9.1.
+func TestDigestAuth_RFC7616(t *testing.T) {
+	cases := []struct {
+		algorithm string
+		response  string
+	}{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+
+	for _, c := range cases {
+		ch := parseDigestChallenges([]string{
+			`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=` + c.algorithm + `, ` +
+				`nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`,
+		})
+		if ch == nil {
+			t.Fatalf("%s: failed to parse challenge", c.algorithm)
+		}
+
+		req, _ := http.NewRequest("GET", "http://www.example.org/dir/index.html", nil)
+		auth, err := ch.authorization(req, "Mufasa", "Circle of Life", 1, "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		params := parseAuthParams(strings.TrimPrefix(auth, "Digest "))
+		if params["response"] != c.response {
+			t.Errorf("%s: response = %q, want %q", c.algorithm, params["response"], c.response)
+		}
+		if params["qop"] != "auth" || params["nc"] != "00000001" {
+			t.Errorf("%s: unexpected qop %q or nc %q", c.algorithm, params["qop"], params["nc"])
+		}
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	server, challenges := digestTestServer(t, "auth-int", 0)
+	defer server.Close()
+
+	client := DigestAuth("Mufasa", "Circle of Life")(http.DefaultClient)
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("POST", server.URL+"/dir/index.html", io.NopCloser(strings.NewReader("payload")))
+		assertStatus(t, client, req, http.StatusAccepted)
+	}
+
+	if n := atomic.LoadInt32(challenges); n != 1 {
+		t.Errorf("Server sent %d challenges, want 1", n)
+	}
+}
+
+func TestDigestAuth_StaleNonce(t *testing.T) {
+	server, challenges := digestTestServer(t, "auth", 2)
+	defer server.Close()
+
+	client := DigestAuth("Mufasa", "Circle of Life")(http.DefaultClient)
+
+	for i := 0; i < 4; i++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		assertStatus(t, client, req, http.StatusAccepted)
+	}
+
+	// The initial challenge, and one stale challenge after every second
+	// request with the same nonce.
+	if n := atomic.LoadInt32(challenges); n != 2 {
+		t.Errorf("Server sent %d challenges, want 2", n)
+	}
+}
+
+func TestDigestAuth_WrongPassword(t *testing.T) {
+	server, _ := digestTestServer(t, "auth", 0)
+	defer server.Close()
+
+	client := DigestAuth("Mufasa", "wrong")(http.DefaultClient)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	assertStatus(t, client, req, http.StatusUnauthorized)
+}
+
+// digestTestServer returns a server that requires MD5 Digest authentication
+// with the given qop. If maxUses is positive, nonces are considered stale
+// after they have been used maxUses times.
+func digestTestServer(t *testing.T, qop string, maxUses int) (*httptest.Server, *int32) {
+	var (
+		mu         sync.Mutex
+		challenges int32
+		nonce      = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+		uses       int
+	)
+
+	h := func(s string) string { return fmt.Sprintf("%x", md5.Sum([]byte(s))) }
+
+	challenge := func(w http.ResponseWriter, stale bool) {
+		atomic.AddInt32(&challenges, 1)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="test", qop="%s", nonce="%s", opaque="xyz", stale=%t`, qop, nonce, stale))
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Digest ") {
+			challenge(w, false)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		p := parseAuthParams(strings.TrimPrefix(auth, "Digest "))
+		body, _ := io.ReadAll(r.Body)
+
+		if maxUses > 0 && uses == maxUses {
+			nonce, uses = randomHex(16), 0
+			challenge(w, true)
+			return
+		}
+		uses++
+
+		ha1 := h("Mufasa:test:Circle of Life")
+		ha2 := h(r.Method + ":" + p["uri"])
+		if qop == "auth-int" {
+			ha2 = h(r.Method + ":" + p["uri"] + ":" + h(string(body)))
+		}
+		want := h(ha1 + ":" + nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":" + qop + ":" + ha2)
+
+		if p["response"] != want || p["opaque"] != "xyz" || p["uri"] != r.URL.RequestURI() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if string(body) != "" && string(body) != "payload" {
+			t.Errorf("Unexpected body %q", body)
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	return server, &challenges
+}
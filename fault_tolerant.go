@@ -1,23 +1,51 @@
 package cmhttp
 
 import (
+	"context"
 	"net/http"
 	"time"
 )
 
+type attemptKey struct{}
+
+type retryObserverKey struct{}
+
 // FaultTolerant retries requests that failed do to network errors
 // attempts times, and sleeps for increasing amounts of time between attempts.
 // If all attempts fail, the last error is returned.
+//
+// Each attempt is made with a shallow copy of the request whose context
+// carries the attempt number, see Attempt.
 func FaultTolerant(attempts int, backoff time.Duration) Decorator {
 	return func(c Client) Client {
 		return ClientFunc(func(r *http.Request) (res *http.Response, err error) {
+			observe, _ := r.Context().Value(retryObserverKey{}).(func(int, error))
+
 			for i := 1; i <= attempts; i++ {
-				if res, err = c.Do(r); err == nil {
+				ctx := context.WithValue(r.Context(), attemptKey{}, i)
+				if res, err = c.Do(r.WithContext(ctx)); err == nil {
 					break
 				}
+				if observe != nil && i < attempts {
+					observe(i, err)
+				}
 				time.Sleep(backoff * time.Duration(i))
 			}
 			return res, err
 		})
 	}
 }
+
+// Attempt returns the number of the current attempt of a request made by
+// FaultTolerant, starting at 1, or 0 if ctx doesn't belong to such a request.
+func Attempt(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey{}).(int)
+	return n
+}
+
+// withRetryObserver returns a context that makes FaultTolerant call observe
+// with the attempt number and the error of every failed attempt that is
+// followed by another one.
+func withRetryObserver(ctx context.Context, observe func(attempt int, err error)) context.Context {
+	return context.WithValue(ctx, retryObserverKey{}, observe)
+}
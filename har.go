@@ -0,0 +1,406 @@
+package cmhttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRecordedBodySize is the number of body bytes recorded by a
+// HARRecorder if no other limit is given.
+const DefaultMaxRecordedBodySize = 1 << 20
+
+// HAROptions configures a HARRecorder.
+type HAROptions struct {
+	// MaxBodySize is the maximum number of bytes of each body that are
+	// recorded. If zero, DefaultMaxRecordedBodySize is used.
+	MaxBodySize int64
+
+	// Base64Binary makes the recorder include binary response bodies base64
+	// encoded. By default, only their size is recorded.
+	Base64Binary bool
+
+	// Redaction is applied to headers and JSON bodies before they are
+	// serialized.
+	Redaction RedactionPolicy
+}
+
+// A HARRecorder records requests and responses in the HTTP Archive (HAR) 1.2
+// format, which can be loaded into browser developer tools. Use its Record
+// method as a Decorator:
+//
+//	har := cmhttp.NewHARRecorder(cmhttp.HAROptions{})
+//	client := cmhttp.Decorate(http.DefaultClient, har.Record)
+//	// make some requests
+//	err := har.WriteFile("requests.har")
+//
+// Like DebugLogged, the recorder reads up to MaxBodySize bytes of each body
+// into a buffer and restores the body afterwards. The receive timing of a
+// response is known once its body has been read completely or closed.
+type HARRecorder struct {
+	opts HAROptions
+
+	mu      sync.Mutex
+	entries []*harRecording
+}
+
+type harRecording struct {
+	started  time.Time
+	request  *http.Request
+	reqBody  []byte
+	response *http.Response
+	respBody []byte
+	err      error
+	phases   *phaseRecorder
+}
+
+// NewHARRecorder returns a new HARRecorder.
+func NewHARRecorder(opts HAROptions) *HARRecorder {
+	if opts.MaxBodySize <= 0 {
+		opts.MaxBodySize = DefaultMaxRecordedBodySize
+	}
+	return &HARRecorder{opts: opts}
+}
+
+// Record is a Decorator that records all requests made with c.
+func (h *HARRecorder) Record(c Client) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		entry := &harRecording{
+			started: time.Now(),
+			phases:  &phaseRecorder{},
+		}
+
+		r2 := r.WithContext(entry.phases.context(r.Context()))
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			entry.reqBody, r2.Body, err = peekBody(r.Body, h.opts.MaxBodySize)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entry.request = r2
+
+		resp, err := c.Do(r2)
+		entry.response, entry.err = resp, err
+		if err == nil {
+			entry.respBody, resp.Body, err = peekBody(resp.Body, h.opts.MaxBodySize)
+			if err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			resp.Body = entry.phases.wrapBody(resp.Body, nil)
+		}
+
+		h.mu.Lock()
+		h.entries = append(h.entries, entry)
+		h.mu.Unlock()
+
+		return resp, err
+	})
+}
+
+// Reset discards all recorded requests.
+func (h *HARRecorder) Reset() {
+	h.mu.Lock()
+	h.entries = nil
+	h.mu.Unlock()
+}
+
+// WriteTo writes all recorded requests to w as HAR document. It implements
+// io.WriterTo.
+func (h *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	entries := make([]harEntry, len(h.entries))
+	for i, rec := range h.entries {
+		entries[i] = h.entry(rec)
+	}
+	h.mu.Unlock()
+
+	doc := harDocument{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "cmhttp", Version: "1"},
+		Entries: entries,
+	}}
+
+	buf, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(append(buf, '\n'))
+	return int64(n), err
+}
+
+// WriteFile writes all recorded requests to the named file as HAR document,
+// creating or truncating it.
+func (h *HARRecorder) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (h *HARRecorder) entry(rec *harRecording) harEntry {
+	r := rec.request
+
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	proto := r.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+
+	e := harEntry{
+		StartedDateTime: rec.started.Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      method,
+			URL:         h.opts.Redaction.RedactURL(r.URL),
+			HTTPVersion: proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(h.opts.Redaction.RedactHeader(r.Header)),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    r.ContentLength,
+		},
+		Cache: struct{}{},
+	}
+
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		if h.opts.Redaction.RedactsQueryParam(name) {
+			value = h.opts.Redaction.replacement()
+		}
+		e.Request.QueryString = append(e.Request.QueryString, harNameValue{name, value})
+	}
+	if len(rec.reqBody) > 0 {
+		contentType := r.Header.Get("Content-Type")
+		text, _, comment := h.content(rec.reqBody, r.ContentLength, contentType, false)
+		e.Request.PostData = &harPostData{MimeType: contentType, Text: text, Comment: comment}
+	}
+
+	e.Response = harResponse{
+		HTTPVersion: proto,
+		Cookies:     []harNameValue{},
+		Headers:     []harNameValue{},
+		Content:     harContent{Size: 0},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if rec.err != nil {
+		e.Error = rec.err.Error()
+	} else {
+		resp := rec.response
+		contentType := resp.Header.Get("Content-Type")
+		text, encoding, comment := h.content(rec.respBody, resp.ContentLength, contentType, h.opts.Base64Binary)
+
+		e.Response.Status = resp.StatusCode
+		e.Response.StatusText = http.StatusText(resp.StatusCode)
+		e.Response.HTTPVersion = resp.Proto
+		e.Response.Headers = harHeaders(h.opts.Redaction.RedactHeader(resp.Header))
+		e.Response.RedirectURL = resp.Header.Get("Location")
+		e.Response.BodySize = resp.ContentLength
+		e.Response.Content = harContent{
+			Size:     int64(len(rec.respBody)),
+			MimeType: contentType,
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		}
+		if resp.ContentLength > e.Response.Content.Size {
+			e.Response.Content.Size = resp.ContentLength
+		}
+	}
+
+	e.Timings = rec.phases.harTimings()
+	e.Time = e.Timings.total()
+
+	return e
+}
+
+// content returns the text, encoding and comment for the HAR representation
+// of body.
+func (h *HARRecorder) content(body []byte, size int64, contentType string, base64Binary bool) (text, encoding, comment string) {
+	if len(body) == 0 {
+		return "", "", ""
+	}
+	if int64(len(body)) >= h.opts.MaxBodySize && size != int64(len(body)) {
+		comment = "truncated"
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	switch {
+	case isTextContentType(contentType):
+		doc, _, ok := h.opts.Redaction.redactTextBody(body, contentType)
+		if !ok {
+			return "", "", "omitted: invalid or truncated JSON cannot be redacted"
+		}
+		return string(doc), "", comment
+	case base64Binary:
+		return base64.StdEncoding.EncodeToString(body), "base64", comment
+	default:
+		return "", "", "binary content omitted"
+	}
+}
+
+// harTimings returns the recorded timings in milliseconds. Timings that do not
+// apply are -1.
+func (rec *phaseRecorder) harTimings() harTimings {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	ms := func(start, end time.Time) float64 {
+		if start.IsZero() || end.IsZero() {
+			return -1
+		}
+		return float64(end.Sub(start)) / float64(time.Millisecond)
+	}
+
+	t := harTimings{
+		DNS:     ms(rec.dnsStart, rec.dnsDone),
+		Connect: ms(rec.connectStart, rec.connectDone),
+		SSL:     ms(rec.tlsStart, rec.tlsDone),
+		Send:    ms(rec.gotConn, rec.wroteRequest),
+		Wait:    ms(rec.wroteRequest, rec.firstByte),
+		Receive: ms(rec.firstByte, rec.bodyDone),
+		Blocked: -1,
+	}
+	if t.Connect >= 0 && t.SSL >= 0 {
+		t.Connect += t.SSL // HAR includes the TLS handshake in connect
+	}
+	for _, d := range []*float64{&t.Send, &t.Wait, &t.Receive} {
+		if *d < 0 {
+			*d = 0 // required by the spec
+		}
+	}
+	return t
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, value := range h[name] {
+			headers = append(headers, harNameValue{name, value})
+		}
+	}
+	return headers
+}
+
+// The following types implement the subset of HAR 1.2 that is needed for
+// recorded client requests, see http://www.softwareishard.com/blog/har-12-spec/.
+
+type harDocument struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+func (t harTimings) total() float64 {
+	total := t.Send + t.Wait + t.Receive
+	for _, d := range []float64{t.Blocked, t.DNS, t.Connect} {
+		if d > 0 {
+			total += d
+		}
+	}
+	return total
+}
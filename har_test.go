@@ -0,0 +1,193 @@
+package cmhttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHARRecorder(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		default:
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":1,"token":"secret-token"}`))
+		}
+	}))
+	defer server.Close()
+
+	har := NewHARRecorder(HAROptions{
+		Base64Binary: true,
+		Redaction:    RedactionPolicy{JSONPaths: []string{"token"}},
+	})
+	client := Decorate(http.DefaultClient, har.Record)
+
+	req, _ := http.NewRequest("POST", server.URL+"/users?b=2&a=x+y", strings.NewReader(`{"name":"alice","token":"t"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	mustDoAndRead(t, client, req)
+
+	req, _ = http.NewRequest("GET", server.URL+"/image", nil)
+	mustDoAndRead(t, client, req)
+
+	failing := Decorate(ClientFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}), har.Record)
+	req, _ = http.NewRequest("GET", "http://example.invalid/", nil)
+	if _, err := failing.Do(req); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	var buf bytes.Buffer
+	if _, err := har.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("HAR contains secrets:\n%s", buf.String())
+	}
+
+	var doc harDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Log.Version != "1.2" {
+		t.Errorf("version = %q, want 1.2", doc.Log.Version)
+	}
+	if len(doc.Log.Entries) != 3 {
+		t.Fatalf("Got %d entries, want 3", len(doc.Log.Entries))
+	}
+
+	post := doc.Log.Entries[0]
+	if post.Request.Method != "POST" || post.Response.Status != 201 {
+		t.Errorf("Unexpected method or status: %s %d", post.Request.Method, post.Response.Status)
+	}
+	wantQuery := []harNameValue{{"b", "2"}, {"a", "x y"}}
+	if len(post.Request.QueryString) != 2 || post.Request.QueryString[0] != wantQuery[0] || post.Request.QueryString[1] != wantQuery[1] {
+		t.Errorf("queryString = %v, want %v", post.Request.QueryString, wantQuery)
+	}
+	if post.Request.PostData == nil || post.Request.PostData.Text != `{"name":"alice","token":"REDACTED"}` {
+		t.Errorf("Unexpected postData: %+v", post.Request.PostData)
+	}
+	if post.Response.Content.Text != `{"id":1,"token":"REDACTED"}` {
+		t.Errorf("Unexpected response content: %+v", post.Response.Content)
+	}
+	if post.Timings.Connect <= 0 || post.Timings.Receive < 0 || post.Time <= 0 {
+		t.Errorf("Unexpected timings: %+v", post.Timings)
+	}
+
+	image := doc.Log.Entries[1]
+	if image.Response.Content.Encoding != "base64" || image.Response.Content.Text != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("Unexpected binary content: %+v", image.Response.Content)
+	}
+	if image.Timings.Connect != -1 {
+		t.Errorf("Reused connection has connect timing %v, want -1", image.Timings.Connect)
+	}
+
+	if failed := doc.Log.Entries[2]; failed.Error != "connection refused" || failed.Response.Status != 0 {
+		t.Errorf("Unexpected failed entry: %+v", failed)
+	}
+
+	name := filepath.Join(t.TempDir(), "requests.har")
+	if err := har.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHARRecorder_RedactsQueryAndUnlabeledJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(`{"token":"secret-token"}`))
+	}))
+	defer server.Close()
+
+	har := NewHARRecorder(HAROptions{
+		Redaction: RedactionPolicy{JSONPaths: []string{"token"}},
+	})
+	client := Decorate(http.DefaultClient, har.Record)
+
+	req, _ := http.NewRequest("GET", server.URL+"/callback?access_token=secret-1&page=2&api_key=secret-2", nil)
+	mustDoAndRead(t, client, req)
+
+	var buf bytes.Buffer
+	if _, err := har.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("HAR contains secrets:\n%s", buf.String())
+	}
+
+	var doc harDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	entry := doc.Log.Entries[0]
+	if want := server.URL + "/callback?access_token=REDACTED&page=2&api_key=REDACTED"; entry.Request.URL != want {
+		t.Errorf("url = %q, want %q", entry.Request.URL, want)
+	}
+	wantQuery := []harNameValue{{"access_token", "REDACTED"}, {"page", "2"}, {"api_key", "REDACTED"}}
+	if !reflect.DeepEqual(entry.Request.QueryString, wantQuery) {
+		t.Errorf("queryString = %v, want %v", entry.Request.QueryString, wantQuery)
+	}
+	if entry.Response.Content.Text != `{"token":"REDACTED"}` {
+		t.Errorf("Unexpected response content: %+v", entry.Response.Content)
+	}
+}
+
+func TestHARRecorder_RedactsFormBodies(t *testing.T) {
+	har := NewHARRecorder(HAROptions{})
+	client := Decorate(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		io.Copy(io.Discard, r.Body)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+	}), har.Record)
+
+	req, _ := http.NewRequest("POST", "http://example.com/token", strings.NewReader("grant_type=password&password=hunter2&client_secret=xyz"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	mustDoAndRead(t, client, req)
+
+	var buf bytes.Buffer
+	if _, err := har.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "xyz") {
+		t.Errorf("HAR contains secrets:\n%s", buf.String())
+	}
+
+	var doc harDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	postData := doc.Log.Entries[0].Request.PostData
+	if want := "grant_type=password&password=REDACTED&client_secret=REDACTED"; postData == nil || postData.Text != want {
+		t.Errorf("postData = %+v, want text %q", postData, want)
+	}
+}
+
+func mustDoAndRead(t *testing.T, c Client, req *http.Request) []byte {
+	t.Helper()
+
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
@@ -0,0 +1,290 @@
+package cmhttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HMACMessage holds the parts of a request that are covered by an HMAC
+// signature.
+type HMACMessage struct {
+	Method     string
+	Path       string // path and query, as sent on the request line
+	Timestamp  string // Unix time in seconds
+	Nonce      string // empty if HMACConfig.NonceHeader is empty
+	BodyDigest string // hex encoded hash of the body
+	Header     http.Header
+}
+
+// An HMACCanonicalizer turns an HMACMessage into the string that is signed.
+type HMACCanonicalizer func(HMACMessage) string
+
+// DefaultHMACCanonicalizer joins method, path, timestamp, nonce and body
+// digest of m with newlines.
+func DefaultHMACCanonicalizer(m HMACMessage) string {
+	return strings.Join([]string{m.Method, m.Path, m.Timestamp, m.Nonce, m.BodyDigest}, "\n")
+}
+
+// HMACConfig configures HMACSigned and VerifyHMAC. Client and server must use
+// equal configurations. All fields except Key are optional.
+type HMACConfig struct {
+	// Key is the shared secret.
+	Key []byte
+
+	// Hash is used for the body digest and the HMAC. Defaults to sha256.New;
+	// sha512.New is a common alternative.
+	Hash func() hash.Hash
+
+	// Canonicalize defaults to DefaultHMACCanonicalizer.
+	Canonicalize HMACCanonicalizer
+
+	// SignatureHeader holds the hex encoded signature. Defaults to
+	// "X-Signature".
+	SignatureHeader string
+
+	// TimestampHeader holds the Unix time at which the request was signed.
+	// Defaults to "X-Signature-Timestamp".
+	TimestampHeader string
+
+	// NonceHeader holds a random value that is unique per request. If empty,
+	// no nonce is sent or verified.
+	NonceHeader string
+
+	// MaxSkew is the maximum difference between the signature timestamp and
+	// the time of verification. Defaults to 5 minutes. Only used by
+	// VerifyHMAC, which also rejects nonces seen within this duration.
+	MaxSkew time.Duration
+
+	// MaxBodySize is the maximum size of request bodies accepted by
+	// VerifyHMAC, which must read the body before the signature can be
+	// verified. Defaults to 10 MiB.
+	MaxBodySize int64
+}
+
+func (cfg HMACConfig) withDefaults() HMACConfig {
+	if cfg.Hash == nil {
+		cfg.Hash = sha256.New
+	}
+	if cfg.Canonicalize == nil {
+		cfg.Canonicalize = DefaultHMACCanonicalizer
+	}
+	if cfg.SignatureHeader == "" {
+		cfg.SignatureHeader = "X-Signature"
+	}
+	if cfg.TimestampHeader == "" {
+		cfg.TimestampHeader = "X-Signature-Timestamp"
+	}
+	if cfg.MaxSkew == 0 {
+		cfg.MaxSkew = 5 * time.Minute
+	}
+	if cfg.MaxBodySize == 0 {
+		cfg.MaxBodySize = 10 << 20
+	}
+	return cfg
+}
+
+// signature returns the hex encoded signature of m.
+func (cfg HMACConfig) signature(m HMACMessage) string {
+	mac := hmac.New(cfg.Hash, cfg.Key)
+	mac.Write([]byte(cfg.Canonicalize(m)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACSigned signs all requests with a keyed hash over the request method,
+// path, a timestamp, an optional nonce and the digest of the body, as
+// configured by cfg. The body is read into memory to compute its digest and
+// is then restored.
+func HMACSigned(cfg HMACConfig) Decorator {
+	cfg = cfg.withDefaults()
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			body, err := readAndRestoreBody(r)
+			if err != nil {
+				return nil, err
+			}
+
+			m := HMACMessage{
+				Method:     r.Method,
+				Path:       r.URL.RequestURI(),
+				Timestamp:  strconv.FormatInt(time.Now().Unix(), 10),
+				BodyDigest: hexDigest(cfg.Hash, body),
+				Header:     r.Header,
+			}
+			if cfg.NonceHeader != "" {
+				m.Nonce = randomHex(16)
+				r.Header.Set(cfg.NonceHeader, m.Nonce)
+			}
+
+			r.Header.Set(cfg.TimestampHeader, m.Timestamp)
+			r.Header.Set(cfg.SignatureHeader, cfg.signature(m))
+
+			return c.Do(r)
+		})
+	}
+}
+
+// VerifyHMAC returns HTTP server middleware that verifies requests signed by
+// HMACSigned with an equal cfg. Requests with a missing or invalid signature,
+// a timestamp outside of cfg.MaxSkew, or a reused nonce are rejected with 401
+// Unauthorized. Requests with a body larger than cfg.MaxBodySize are rejected
+// with 413 Request Entity Too Large.
+func VerifyHMAC(cfg HMACConfig) func(http.Handler) http.Handler {
+	cfg = cfg.withDefaults()
+	nonces := newNonceCache(cfg.MaxSkew)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodySize)
+			}
+
+			if err := verifyHMAC(cfg, nonces, r); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func verifyHMAC(cfg HMACConfig, nonces *nonceCache, r *http.Request) error {
+	signature := r.Header.Get(cfg.SignatureHeader)
+	if signature == "" {
+		return fmt.Errorf("missing %s header", cfg.SignatureHeader)
+	}
+
+	timestamp := r.Header.Get(cfg.TimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header", cfg.TimestampHeader)
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > cfg.MaxSkew || skew < -cfg.MaxSkew {
+		return fmt.Errorf("signature timestamp is too far from the current time")
+	}
+
+	// The body is read only after the cheap checks above, and limited by
+	// VerifyHMAC, so that unauthenticated clients cannot make the server
+	// buffer arbitrarily large bodies.
+	body, err := readAndRestoreBody(r)
+	if err != nil {
+		return err
+	}
+
+	m := HMACMessage{
+		Method:     r.Method,
+		Path:       r.URL.RequestURI(),
+		Timestamp:  timestamp,
+		BodyDigest: hexDigest(cfg.Hash, body),
+		Header:     r.Header,
+	}
+	if cfg.NonceHeader != "" {
+		m.Nonce = r.Header.Get(cfg.NonceHeader)
+		if m.Nonce == "" {
+			return fmt.Errorf("missing %s header", cfg.NonceHeader)
+		}
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(cfg.signature(m))) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	// Only remember nonces of valid requests, so that forged requests cannot
+	// block legitimate ones.
+	if m.Nonce != "" && !nonces.add(m.Nonce) {
+		return fmt.Errorf("nonce has already been used")
+	}
+
+	return nil
+}
+
+// readAndRestoreBody reads the body of r and replaces it with an equivalent
+// reader, so that it can still be read by whoever handles r next.
+func readAndRestoreBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return body, nil
+}
+
+func hexDigest(h func() hash.Hash, data []byte) string {
+	d := h()
+	d.Write(data)
+	return hex.EncodeToString(d.Sum(nil))
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return hex.EncodeToString(b)
+}
+
+// nonceCache remembers nonces for a limited time.
+type nonceCache struct {
+	ttl time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, nonces: make(map[string]time.Time)}
+}
+
+// add remembers nonce and reports whether it has not been seen before.
+func (nc *nonceCache) add(nonce string) bool {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(nc.pruned) > nc.ttl {
+		for n, expiry := range nc.nonces {
+			if now.After(expiry) {
+				delete(nc.nonces, n)
+			}
+		}
+		nc.pruned = now
+	}
+
+	if expiry, ok := nc.nonces[nonce]; ok && now.Before(expiry) {
+		return false
+	}
+
+	// Timestamps may be up to ttl in the future, so a nonce must be kept for
+	// twice as long to cover the whole window in which its request is valid.
+	nc.nonces[nonce] = now.Add(2 * nc.ttl)
+	return true
+}
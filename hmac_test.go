@@ -0,0 +1,159 @@
+package cmhttp
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACSigned(t *testing.T) {
+	cfg := HMACConfig{
+		Key:         []byte("s3cret"),
+		Hash:        sha512.New,
+		NonceHeader: "X-Nonce",
+	}
+
+	var body string
+	server := httptest.NewServer(VerifyHMAC(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	defer server.Close()
+
+	var sent *http.Request
+	client := HMACSigned(cfg)(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r
+		return http.DefaultClient.Do(r)
+	}))
+
+	req, _ := http.NewRequest("POST", server.URL+"/hooks?x=1", strings.NewReader("payload"))
+	assertStatus(t, client, req, http.StatusAccepted)
+	if body != "payload" {
+		t.Errorf("Handler received body %q, want %q", body, "payload")
+	}
+
+	// Replaying the exact same request must fail because of the nonce.
+	replay, _ := http.NewRequest("POST", server.URL+"/hooks?x=1", strings.NewReader("payload"))
+	replay.Header = sent.Header.Clone()
+	assertStatus(t, http.DefaultClient, replay, http.StatusUnauthorized)
+
+	// Tampering with the body must fail.
+	tampered, _ := http.NewRequest("POST", server.URL+"/hooks?x=1", strings.NewReader("payloaf"))
+	tampered.Header = sent.Header.Clone()
+	tampered.Header.Set("X-Nonce", "other")
+	assertStatus(t, http.DefaultClient, tampered, http.StatusUnauthorized)
+
+	// Signing with another key must fail.
+	other := cfg
+	other.Key = []byte("wrong")
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	assertStatus(t, HMACSigned(other)(http.DefaultClient), req, http.StatusUnauthorized)
+}
+
+func TestHMACSigned_CustomCanonicalizer(t *testing.T) {
+	cfg := HMACConfig{
+		Key:             []byte("s3cret"),
+		SignatureHeader: "X-Partner-Signature",
+		TimestampHeader: "X-Partner-Time",
+		Canonicalize: func(m HMACMessage) string {
+			return m.Timestamp + "." + m.Header.Get("X-Partner-ID") + "." + m.BodyDigest
+		},
+	}
+
+	server := httptest.NewServer(VerifyHMAC(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("PUT", server.URL, strings.NewReader("{}"))
+	req.Header.Set("X-Partner-ID", "42")
+	assertStatus(t, HMACSigned(cfg)(http.DefaultClient), req, http.StatusAccepted)
+}
+
+func TestVerifyHMAC_MaxBodySize(t *testing.T) {
+	cfg := HMACConfig{Key: []byte("s3cret"), MaxBodySize: 10}
+
+	server := httptest.NewServer(VerifyHMAC(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader("0123456789"))
+	assertStatus(t, HMACSigned(cfg)(http.DefaultClient), req, http.StatusAccepted)
+
+	req, _ = http.NewRequest("POST", server.URL, strings.NewReader(strings.Repeat("x", 11)))
+	assertStatus(t, HMACSigned(cfg)(http.DefaultClient), req, http.StatusRequestEntityTooLarge)
+
+	// Unsigned requests are rejected before their body is read.
+	req, _ = http.NewRequest("POST", server.URL, strings.NewReader(strings.Repeat("x", 11)))
+	assertStatus(t, http.DefaultClient, req, http.StatusUnauthorized)
+}
+
+func TestMessageSigned(t *testing.T) {
+	cfg := MessageSignatureConfig{
+		KeyID: "test-key",
+		Key:   []byte("s3cret"),
+	}
+
+	server := httptest.NewServer(VerifyMessageSignature(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL+"/foo?bar=baz", strings.NewReader(`{"hello": "world"}`))
+	assertStatus(t, MessageSigned(cfg)(http.DefaultClient), req, http.StatusAccepted)
+
+	if got := req.Header.Get("Content-Digest"); got != "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:" {
+		t.Errorf("Unexpected Content-Digest %q", got)
+	}
+
+	// Covering fewer components than required must fail.
+	weak := cfg
+	weak.Components = []string{"@method"}
+	req, _ = http.NewRequest("POST", server.URL+"/foo", strings.NewReader("{}"))
+	assertStatus(t, MessageSigned(weak)(http.DefaultClient), req, http.StatusUnauthorized)
+}
+
+// TestVerifyMessageSignature_RFC9421 verifies the example in RFC 9421,
+// appendix B.2.5.
+func TestVerifyMessageSignature_RFC9421(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString("uzvJfB4u3N0Jy4T7NZ75MDVcr8zSTInedJtkgcu46YW4XByzNJjxBdtjUkdJPBtbmHhIDi6pcl8jsasjlTMtDQ==")
+	cfg := MessageSignatureConfig{
+		KeyID:      "test-shared-secret",
+		Key:        key,
+		Label:      "sig-b25",
+		Components: []string{"date", "@authority", "content-type"},
+		MaxAge:     100 * 365 * 24 * time.Hour,
+	}
+
+	req := httptest.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Signature-Input", `sig-b25=("date" "@authority" "content-type");created=1618884473;keyid="test-shared-secret"`)
+	req.Header.Set("Signature", `sig-b25=:pxcQw6G3AjtMBQjwo8XzkZf/bws5LelbaMk5rGIGtE8=:`)
+
+	if err := verifyMessageSignature(cfg, req); err != nil {
+		t.Error(err)
+	}
+}
+
+func assertStatus(t *testing.T, c Client, req *http.Request, want int) {
+	t.Helper()
+
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		b, _ := io.ReadAll(resp.Body)
+		t.Errorf("Unexpected response status %d, want %d: %s", resp.StatusCode, want, b)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // InstrumentedRequestDurations instruments the client by tracking the request
@@ -14,6 +15,7 @@ import (
 // "method") and response code (label name "code").
 //
 // InstrumentedRequestDurations will set the Name and Help fields of opts.
+// Use InstrumentedRequestDurationsWith for native histograms and exemplars.
 func InstrumentedRequestDurations(opts prometheus.HistogramOpts) Decorator {
 	opts.Name = "request_duration_seconds"
 	opts.Help = "The HTTP request duration in seconds."
@@ -40,6 +42,104 @@ func InstrumentedRequestDurations(opts prometheus.HistogramOpts) Decorator {
 	}
 }
 
+// RequestDurationOpts configures InstrumentedRequestDurationsWith.
+type RequestDurationOpts struct {
+	prometheus.HistogramOpts
+
+	// NativeHistogram makes the histogram a native histogram with a bucket
+	// factor of 1.1, unless NativeHistogramBucketFactor is set. Classic
+	// buckets are only kept if Buckets is set explicitly.
+	NativeHistogram bool
+
+	// Exemplars attaches the trace and span ID of the request to observations
+	// as exemplar (labels "trace_id" and "span_id"). The IDs are taken from the
+	// OpenTelemetry span in the request's context, see Traced, or from the
+	// traceparent header of the request or its Correlation.
+	Exemplars bool
+}
+
+// InstrumentedRequestDurationsWith is like InstrumentedRequestDurations but
+// registers the histogram vector with reg, or with the default Registerer if
+// reg is nil, and supports native histograms and exemplars. If the Name and
+// Help fields of opts are empty, they are set to "request_duration_seconds"
+// and a generic description.
+func InstrumentedRequestDurationsWith(reg prometheus.Registerer, opts RequestDurationOpts) Decorator {
+	if opts.Name == "" {
+		opts.Name = "request_duration_seconds"
+	}
+	if opts.Help == "" {
+		opts.Help = "The HTTP request duration in seconds."
+	}
+	if opts.NativeHistogram && opts.NativeHistogramBucketFactor <= 1 {
+		opts.NativeHistogramBucketFactor = 1.1
+	}
+
+	durations := mustRegisterOrGet(reg,
+		prometheus.NewHistogramVec(opts.HistogramOpts, []string{"method", "code"}),
+	).(*prometheus.HistogramVec)
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			begin := time.Now()
+			resp, err := c.Do(r)
+			if err != nil {
+				return resp, err
+			}
+
+			elapsed := time.Since(begin).Seconds()
+			observer := durations.WithLabelValues(sanitizeMethod(r.Method), sanitizeCode(resp.StatusCode))
+
+			var exemplar prometheus.Labels
+			if opts.Exemplars {
+				exemplar = exemplarLabels(r)
+			}
+
+			if exemplar != nil {
+				observer.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed, exemplar)
+			} else {
+				observer.Observe(elapsed)
+			}
+
+			return resp, err
+		})
+	}
+}
+
+// exemplarLabels returns the trace and span ID of r as exemplar labels, or nil
+// if r does not belong to a trace.
+func exemplarLabels(r *http.Request) prometheus.Labels {
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		return prometheus.Labels{
+			"trace_id": sc.TraceID().String(),
+			"span_id":  sc.SpanID().String(),
+		}
+	}
+
+	traceparent := r.Header.Get("Traceparent")
+	if traceparent == "" {
+		traceparent = CorrelationFromContext(r.Context()).TraceParent
+	}
+
+	// version-traceid-spanid-flags, see https://www.w3.org/TR/trace-context/
+	parts := strings.Split(traceparent, "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil
+	}
+	traceID, err := trace.TraceIDFromHex(parts[1])
+	if err != nil {
+		return nil
+	}
+	spanID, err := trace.SpanIDFromHex(parts[2])
+	if err != nil {
+		return nil
+	}
+
+	return prometheus.Labels{
+		"trace_id": traceID.String(),
+		"span_id":  spanID.String(),
+	}
+}
+
 var metrics struct {
 	reqCnt *prometheus.CounterVec
 	reqDur prometheus.Summary
@@ -73,6 +173,9 @@ func Instrumented(name string) Decorator {
 // InstrumentedWithOpts is like Instrumented but allows changing Namespace (""
 // by default) and/or Subsystem ("http_client" by default) and adding
 // ConstLabels. All other fields of opts are ignored.
+//
+// The metrics are registered only once, with the options of the first call.
+// Use InstrumentedWith to instrument multiple clients differently.
 func InstrumentedWithOpts(opts prometheus.SummaryOpts) Decorator {
 	if metrics.reqCnt == nil {
 		// make sure we register metrics only once
@@ -123,8 +226,8 @@ func InstrumentedWithOpts(opts prometheus.SummaryOpts) Decorator {
 
 				metrics.reqCnt.WithLabelValues(method, code).Inc()
 				metrics.reqDur.Observe(elapsed)
-				metrics.reqSz.Observe(float64(respLength))
-				metrics.resSz.Observe(float64(requestSize))
+				metrics.reqSz.Observe(float64(requestSize))
+				metrics.resSz.Observe(float64(respLength))
 			}()
 
 			return resp, err
@@ -132,6 +235,25 @@ func InstrumentedWithOpts(opts prometheus.SummaryOpts) Decorator {
 	}
 }
 
+// mustRegisterOrGet registers c with reg, or with the default Registerer if
+// reg is nil. If an equal collector has already been registered, the existing
+// one is returned instead, so that decorators can be created repeatedly.
+// Other registration errors cause a panic.
+func mustRegisterOrGet(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+
+	return c
+}
+
 // computeApproximateRequestSize has been mostly copied from the
 // prometheus.InstrumentHandler logic.
 func computeApproximateRequestSize(r *http.Request) int {
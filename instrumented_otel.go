@@ -0,0 +1,130 @@
+package cmhttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// InstrumentedOTel records the OpenTelemetry HTTP client metrics
+// http.client.request.duration, http.client.request.body.size,
+// http.client.response.body.size and http.client.active_requests with meters
+// from mp, or from the global MeterProvider if mp is nil.
+//
+// The attributes are derived like the labels of InstrumentedWith, so that
+// both can be used interchangeably: the method, the host (if opts.HostLabel
+// is set) as server.address and server.port, the route name (if
+// opts.RouteLabel is set and the route is not OtherRoute, like in Traced) as
+// url.template, the status code and the error
+// class (or the status code for responses with status 400 or higher) as
+// error.type. ConstLabels are added as attributes, and all other fields of
+// opts are ignored.
+func InstrumentedOTel(mp metric.MeterProvider, opts InstrumentOpts) Decorator {
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	// Errors can only be caused by invalid instrument names or options,
+	// which are fixed.
+	durations, _ := meter.Float64Histogram("http.client.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of HTTP client requests."),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10),
+	)
+	requestSizes, _ := meter.Int64Histogram("http.client.request.body.size",
+		metric.WithUnit("By"),
+		metric.WithDescription("Size of HTTP client request bodies."),
+	)
+	responseSizes, _ := meter.Int64Histogram("http.client.response.body.size",
+		metric.WithUnit("By"),
+		metric.WithDescription("Size of HTTP client response bodies."),
+	)
+	active, _ := meter.Int64UpDownCounter("http.client.active_requests",
+		metric.WithUnit("{request}"),
+		metric.WithDescription("Number of active HTTP requests."),
+	)
+
+	var constAttrs []attribute.KeyValue
+	for name, value := range opts.ConstLabels {
+		constAttrs = append(constAttrs, attribute.String(name, value))
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			// A context without cancellation, so that measurements are
+			// recorded even if the request has been canceled.
+			ctx := context.WithoutCancel(r.Context())
+
+			l := newRequestLabels(r, opts)
+			base := metric.WithAttributes(append(l.otelAttributes(), constAttrs...)...)
+			active.Add(ctx, 1, base)
+
+			begin := time.Now()
+			resp, err := c.Do(r)
+			elapsed := time.Since(begin).Seconds()
+
+			l = l.withOutcome(resp, err)
+			withResult := metric.WithAttributes(append(l.otelAttributes(), constAttrs...)...)
+			durations.Record(ctx, elapsed, withResult)
+			if r.ContentLength >= 0 {
+				requestSizes.Record(ctx, r.ContentLength, withResult)
+			}
+
+			if err != nil {
+				active.Add(ctx, -1, base)
+				return resp, err
+			}
+
+			resp.Body = &instrumentedBody{ReadCloser: resp.Body, done: func(n int64) {
+				responseSizes.Record(ctx, n, withResult)
+				active.Add(ctx, -1, base)
+			}}
+
+			return resp, err
+		})
+	}
+}
+
+// otelAttributes returns the labels as attributes following the
+// OpenTelemetry HTTP semantic conventions.
+func (l requestLabels) otelAttributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(strings.ToUpper(l.method)),
+	}
+
+	if l.withHost {
+		if host, port, err := net.SplitHostPort(l.host); err == nil {
+			attrs = append(attrs, semconv.ServerAddress(host))
+			if p, err := strconv.Atoi(port); err == nil {
+				attrs = append(attrs, semconv.ServerPort(p))
+			}
+		} else {
+			attrs = append(attrs, semconv.ServerAddress(l.host))
+		}
+	}
+	if l.withRoute && l.route != OtherRoute {
+		attrs = append(attrs, semconv.URLTemplate(routeTemplate(l.route, strings.ToUpper(l.method))))
+	}
+
+	if l.code != "" {
+		code, _ := strconv.Atoi(l.code)
+		attrs = append(attrs, semconv.HTTPResponseStatusCode(code))
+		if code >= 400 {
+			attrs = append(attrs, semconv.ErrorTypeKey.String(l.code))
+		}
+	}
+	if l.errorClass != "" {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(l.errorClass))
+	}
+
+	return attrs
+}
@@ -0,0 +1,99 @@
+package cmhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestInstrumentedOTel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	client := Decorate(http.DefaultClient,
+		InstrumentedOTel(mp, InstrumentOpts{HostLabel: true, RouteLabel: true}),
+		Routed("POST /places/{id}"),
+	)
+
+	req, _ := http.NewRequest("POST", server.URL+"/places/1", strings.NewReader("body"))
+	mustDoAndRead(t, client, req)
+
+	req, _ = http.NewRequest("GET", server.URL+"/missing", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m.Data
+		}
+	}
+
+	// The body of the second response has not been closed yet.
+	active := metrics["http.client.active_requests"].(metricdata.Sum[int64])
+	var inFlight int64
+	for _, dp := range active.DataPoints {
+		inFlight += dp.Value
+	}
+	if inFlight != 1 {
+		t.Errorf("Got %d active requests, want 1", inFlight)
+	}
+	resp.Body.Close()
+
+	durations := metrics["http.client.request.duration"].(metricdata.Histogram[float64])
+	if len(durations.DataPoints) != 2 {
+		t.Fatalf("Got %d duration data points, want 2", len(durations.DataPoints))
+	}
+
+	host := server.Listener.Addr().String()
+	for _, dp := range durations.DataPoints {
+		method, _ := dp.Attributes.Value("http.request.method")
+		status, _ := dp.Attributes.Value("http.response.status_code")
+		template, hasTemplate := dp.Attributes.Value("url.template")
+		errorType, hasError := dp.Attributes.Value("error.type")
+		address, _ := dp.Attributes.Value("server.address")
+
+		if !strings.HasPrefix(host, address.AsString()+":") {
+			t.Errorf("server.address = %q, want host of %s", address.AsString(), host)
+		}
+
+		switch method.AsString() {
+		case "POST":
+			if status.AsInt64() != 200 || template.AsString() != "/places/{id}" || hasError {
+				t.Errorf("Unexpected attributes for POST: %v", dp.Attributes.ToSlice())
+			}
+		case "GET":
+			if status.AsInt64() != 404 || hasTemplate || errorType.AsString() != "404" {
+				t.Errorf("Unexpected attributes for GET: %v", dp.Attributes.ToSlice())
+			}
+		default:
+			t.Errorf("Unexpected method attribute: %v", method)
+		}
+	}
+
+	requestSizes := metrics["http.client.request.body.size"].(metricdata.Histogram[int64])
+	for _, dp := range requestSizes.DataPoints {
+		if m, _ := dp.Attributes.Value("http.request.method"); m.AsString() == "POST" && dp.Sum != 4 {
+			t.Errorf("Request body size sum = %d, want 4", dp.Sum)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package cmhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInstrumentedRequestDurationsWith(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reg := prometheus.NewRegistry()
+
+	client := Decorate(http.DefaultClient,
+		InstrumentedRequestDurationsWith(reg, RequestDurationOpts{
+			HistogramOpts: prometheus.HistogramOpts{
+				Buckets: prometheus.DefBuckets,
+			},
+			NativeHistogram: true,
+			Exemplars:       true,
+		}),
+		Traced(tp, propagation.TraceContext{}),
+	)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	mustDoAndRead(t, client, req)
+
+	h := gatherHistogram(t, reg, "request_duration_seconds")
+	if h.GetSchema() == 0 || h.GetZeroThreshold() == 0 {
+		t.Errorf("Histogram is not a native histogram: schema %d, zero threshold %v", h.GetSchema(), h.GetZeroThreshold())
+	}
+	if len(h.Bucket) == 0 {
+		t.Error("Histogram has no classic buckets")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Got %d spans, want 1", len(spans))
+	}
+	want := map[string]string{
+		"trace_id": spans[0].SpanContext.TraceID().String(),
+		"span_id":  spans[0].SpanContext.SpanID().String(),
+	}
+
+	var found bool
+	for _, b := range h.Bucket {
+		if b.Exemplar == nil {
+			continue
+		}
+		found = true
+		for _, l := range b.Exemplar.Label {
+			if want[l.GetName()] != l.GetValue() {
+				t.Errorf("Exemplar label %s = %q, want %q", l.GetName(), l.GetValue(), want[l.GetName()])
+			}
+		}
+	}
+	if !found {
+		t.Error("Histogram has no exemplar")
+	}
+}
+
+func TestExemplarLabels(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if l := exemplarLabels(req); l != nil {
+		t.Errorf("exemplarLabels() = %v for request without trace", l)
+	}
+
+	ctx := WithCorrelation(req.Context(), Correlation{
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	})
+	l := exemplarLabels(req.WithContext(ctx))
+	if l["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || l["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("exemplarLabels() = %v", l)
+	}
+
+	req.Header.Set("Traceparent", "00-invalid")
+	if l := exemplarLabels(req); l != nil {
+		t.Errorf("exemplarLabels() = %v for invalid traceparent", l)
+	}
+}
+
+func gatherHistogram(t *testing.T, g prometheus.Gatherer, name string) *dto.Histogram {
+	t.Helper()
+
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name && len(f.Metric) > 0 {
+			return f.Metric[0].Histogram
+		}
+	}
+
+	t.Fatalf("Metric %s not found", name)
+	return nil
+}
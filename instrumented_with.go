@@ -0,0 +1,374 @@
+package cmhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentOpts configures InstrumentedWith.
+type InstrumentOpts struct {
+	// Namespace and Subsystem prefix the metric names. Subsystem defaults to
+	// "cmhttp_client", so that the metrics don't collide with those of
+	// Instrumented and InstrumentedWithOpts.
+	Namespace string
+	Subsystem string
+
+	// ConstLabels are added to all metrics, e.g. to tell clients apart.
+	ConstLabels prometheus.Labels
+
+	// HostLabel adds a "host" label with the host of the request URL to all
+	// metrics.
+	HostLabel bool
+
+	// RouteLabel adds a "route" label with the route name of the request to
+	// all metrics, see WithRoute and Routed. Requests without a route name are
+	// labeled OtherRoute.
+	RouteLabel bool
+
+	// InFlight adds the gauge requests_in_flight with the number of requests
+	// that have been started but whose response body has not been read
+	// completely or closed yet.
+	InFlight bool
+
+	// TransportErrors adds the counter transport_errors_total with the number
+	// of requests that failed without a response, partitioned by error class
+	// ("error" label).
+	TransportErrors bool
+
+	// TimeToFirstByte adds the histogram time_to_first_byte_seconds with the
+	// time from the start of a request until the first byte of the response
+	// has been received.
+	TimeToFirstByte bool
+}
+
+// InstrumentedWith registers the following metrics with reg, or with the
+// default Registerer if reg is nil, and collects them:
+//
+//   - requests_total (CounterVec)
+//   - request_duration_seconds (HistogramVec)
+//   - request_size_bytes (SummaryVec)
+//   - response_size_bytes (SummaryVec)
+//
+// All metrics are partitioned by HTTP method ("method" label), and, if
+// opts.HostLabel or opts.RouteLabel are set, by host ("host" label) and route
+// name ("route" label). requests_total and
+// request_duration_seconds are additionally partitioned by status code ("code"
+// label) and error class ("error" label). Requests that failed without a
+// response have an empty code and one of the error classes "timeout", "dns",
+// "refused", "tls", "canceled" and "other"; all other requests have an empty
+// error class. The response size is observed when the response body has been
+// read completely or closed.
+//
+// Further metrics can be enabled with opts, see InstrumentOpts. They are
+// partitioned by method and host like the other metrics.
+//
+// Unlike InstrumentedWithOpts, InstrumentedWith can be called for multiple
+// clients with different ConstLabels. If it is called again with the same
+// options, the collectors registered by the first call are reused. All calls
+// that register metrics with the same names in the same Registerer must use
+// the same HostLabel and RouteLabel options; InstrumentedWith panics
+// otherwise. Use a different Namespace or Subsystem to label some clients
+// differently.
+func InstrumentedWith(reg prometheus.Registerer, opts InstrumentOpts) Decorator {
+	m := newClientMetrics(reg, opts)
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			l := newRequestLabels(r, opts)
+			if m.inFlight != nil {
+				m.inFlight.With(l.base()).Inc()
+			}
+
+			begin := time.Now()
+			if m.timeToFirstByte != nil {
+				r = r.WithContext(httptrace.WithClientTrace(r.Context(), &httptrace.ClientTrace{
+					GotFirstResponseByte: func() {
+						m.timeToFirstByte.With(l.base()).Observe(time.Since(begin).Seconds())
+					},
+				}))
+			}
+
+			resp, err := c.Do(r)
+			elapsed := time.Since(begin).Seconds()
+
+			l = l.withOutcome(resp, err)
+			m.requests.With(l.withResult()).Inc()
+			m.durations.With(l.withResult()).Observe(elapsed)
+			m.requestSizes.With(l.base()).Observe(float64(computeApproximateRequestSize(r)))
+
+			if err != nil {
+				if m.transportErrors != nil {
+					m.transportErrors.With(l.withError()).Inc()
+				}
+				if m.inFlight != nil {
+					m.inFlight.With(l.base()).Dec()
+				}
+				return resp, err
+			}
+
+			resp.Body = &instrumentedBody{ReadCloser: resp.Body, done: func(n int64) {
+				m.responseSizes.With(l.base()).Observe(float64(n))
+				if m.inFlight != nil {
+					m.inFlight.With(l.base()).Dec()
+				}
+			}}
+
+			return resp, err
+		})
+	}
+}
+
+type clientMetrics struct {
+	requests      *prometheus.CounterVec
+	durations     *prometheus.HistogramVec
+	requestSizes  *prometheus.SummaryVec
+	responseSizes *prometheus.SummaryVec
+
+	// optional metrics, nil unless enabled
+	inFlight        *prometheus.GaugeVec
+	transportErrors *prometheus.CounterVec
+	timeToFirstByte *prometheus.HistogramVec
+}
+
+func newClientMetrics(reg prometheus.Registerer, opts InstrumentOpts) *clientMetrics {
+	if opts.Subsystem == "" {
+		opts.Subsystem = "cmhttp_client"
+	}
+
+	base := []string{"method"}
+	if opts.HostLabel {
+		base = append(base, "host")
+	}
+	if opts.RouteLabel {
+		base = append(base, "route")
+	}
+	withResult := append(base[:len(base):len(base)], "code", "error")
+
+	m := &clientMetrics{
+		requests: registerClientMetric(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "requests_total",
+			Help:        "Total number of HTTP requests made.",
+			ConstLabels: opts.ConstLabels,
+		}, withResult)).(*prometheus.CounterVec),
+
+		durations: registerClientMetric(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "request_duration_seconds",
+			Help:        "The HTTP request latencies in seconds.",
+			ConstLabels: opts.ConstLabels,
+		}, withResult)).(*prometheus.HistogramVec),
+
+		requestSizes: registerClientMetric(reg, prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "request_size_bytes",
+			Help:        "The HTTP request sizes in bytes.",
+			ConstLabels: opts.ConstLabels,
+		}, base)).(*prometheus.SummaryVec),
+
+		responseSizes: registerClientMetric(reg, prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "response_size_bytes",
+			Help:        "The HTTP response sizes in bytes.",
+			ConstLabels: opts.ConstLabels,
+		}, base)).(*prometheus.SummaryVec),
+	}
+
+	if opts.InFlight {
+		m.inFlight = registerClientMetric(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "requests_in_flight",
+			Help:        "The number of HTTP requests in flight.",
+			ConstLabels: opts.ConstLabels,
+		}, base)).(*prometheus.GaugeVec)
+	}
+	if opts.TransportErrors {
+		m.transportErrors = registerClientMetric(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "transport_errors_total",
+			Help:        "Total number of HTTP requests that failed without a response.",
+			ConstLabels: opts.ConstLabels,
+		}, append(base[:len(base):len(base)], "error"))).(*prometheus.CounterVec)
+	}
+	if opts.TimeToFirstByte {
+		m.timeToFirstByte = registerClientMetric(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Subsystem:   opts.Subsystem,
+			Name:        "time_to_first_byte_seconds",
+			Help:        "The time until the first byte of HTTP responses has been received in seconds.",
+			ConstLabels: opts.ConstLabels,
+		}, base)).(*prometheus.HistogramVec)
+	}
+
+	return m
+}
+
+// registerClientMetric is like mustRegisterOrGet, but explains the most likely
+// cause of a conflict with an existing metric.
+func registerClientMetric(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return are.ExistingCollector
+	}
+	panic(fmt.Errorf("cmhttp: registering client metrics: %w (clients sharing a Registerer, Namespace and Subsystem must use the same HostLabel and RouteLabel options)", err))
+}
+
+// requestLabels are the label values that describe a request in metrics.
+type requestLabels struct {
+	method     string
+	host       string
+	route      string
+	code       string
+	errorClass string
+
+	withHost  bool
+	withRoute bool
+}
+
+// newRequestLabels returns the labels of r before it is sent. Inner decorators
+// may modify r, e.g. its URL, so the labels must not be derived from r again
+// after the request has been made; use withOutcome instead.
+func newRequestLabels(r *http.Request, opts InstrumentOpts) requestLabels {
+	l := requestLabels{
+		method:    sanitizeMethod(r.Method),
+		withHost:  opts.HostLabel,
+		withRoute: opts.RouteLabel,
+	}
+	if l.method == "" {
+		l.method = "get"
+	}
+	if l.withHost {
+		l.host = r.URL.Host
+	}
+	if l.withRoute {
+		l.route = RouteFromContext(r.Context())
+	}
+	return l
+}
+
+// withOutcome returns a copy of l with the status code of resp or the error
+// class of err.
+func (l requestLabels) withOutcome(resp *http.Response, err error) requestLabels {
+	if err != nil {
+		l.errorClass = errorClass(err)
+	} else if resp != nil {
+		l.code = sanitizeCode(resp.StatusCode)
+	}
+	return l
+}
+
+// base returns the labels that do not depend on the outcome of the request.
+func (l requestLabels) base() prometheus.Labels {
+	labels := prometheus.Labels{"method": l.method}
+	if l.withHost {
+		labels["host"] = l.host
+	}
+	if l.withRoute {
+		labels["route"] = l.route
+	}
+	return labels
+}
+
+// withError returns the base labels plus error class.
+func (l requestLabels) withError() prometheus.Labels {
+	labels := l.base()
+	labels["error"] = l.errorClass
+	return labels
+}
+
+// withResult returns the base labels plus code and error class.
+func (l requestLabels) withResult() prometheus.Labels {
+	labels := l.base()
+	labels["code"] = l.code
+	labels["error"] = l.errorClass
+	return labels
+}
+
+// errorClass returns one of "timeout", "dns", "refused", "tls", "canceled"
+// and "other" for an error returned by a Client.
+func errorClass(err error) string {
+	var (
+		netErr       net.Error
+		dnsErr       *net.DNSError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &recordErr),
+		errors.As(err, &alertErr),
+		errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr):
+		return "tls"
+	default:
+		return "other"
+	}
+}
+
+// instrumentedBody counts the bytes read from a response body and calls done
+// with the count once the body has been read completely or closed.
+type instrumentedBody struct {
+	io.ReadCloser
+	n    int64
+	done func(n int64)
+}
+
+func (b *instrumentedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *instrumentedBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *instrumentedBody) finish() {
+	if b.done != nil {
+		b.done(b.n)
+		b.done = nil
+	}
+}
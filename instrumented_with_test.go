@@ -0,0 +1,200 @@
+package cmhttp
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInstrumentedWith(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	// A port without a listener, to provoke "connection refused".
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refusedURL := "http://" + l.Addr().String()
+	l.Close()
+
+	reg := prometheus.NewRegistry()
+	opts := InstrumentOpts{
+		ConstLabels: prometheus.Labels{"name": "a"},
+		HostLabel:   true,
+	}
+	a := InstrumentedWith(reg, opts)(http.DefaultClient)
+	b := InstrumentedWith(reg, InstrumentOpts{
+		ConstLabels: prometheus.Labels{"name": "b"},
+		HostLabel:   true,
+	})(http.DefaultClient)
+
+	// Creating the same decorator twice must not panic.
+	InstrumentedWith(reg, opts)
+
+	for _, c := range []Client{a, a, b} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		mustDoAndRead(t, c, req)
+	}
+	req, _ := http.NewRequest("POST", refusedURL, nil)
+	if _, err := a.Do(req); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	m := newClientMetrics(reg, opts)
+	host := server.Listener.Addr().String()
+
+	if n := testutil.ToFloat64(m.requests.WithLabelValues("get", host, "200", "")); n != 2 {
+		t.Errorf("Got %v successful requests, want 2", n)
+	}
+	if n := testutil.ToFloat64(m.requests.WithLabelValues("post", l.Addr().String(), "", "refused")); n != 1 {
+		t.Errorf("Got %v refused requests, want 1", n)
+	}
+
+	var sizes dto.Metric
+	m.responseSizes.WithLabelValues("get", host).(prometheus.Metric).Write(&sizes)
+	if got := sizes.Summary.GetSampleSum(); got != 10 {
+		t.Errorf("Response sizes sum = %v, want 10", got)
+	}
+
+	m.requestSizes.WithLabelValues("get", host).(prometheus.Metric).Write(&sizes)
+	if got := sizes.Summary.GetSampleCount(); got != 2 {
+		t.Errorf("Got %d request size observations, want 2", got)
+	}
+
+	if n := testutil.CollectAndCount(reg, "cmhttp_client_requests_total"); n != 3 {
+		t.Errorf("Got %d requests_total series, want 3", n)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.Canceled, "canceled"},
+		{&url.Error{Op: "Get", Err: context.DeadlineExceeded}, "timeout"},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, "dns"},
+		{&net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}, "timeout"},
+		{fmt.Errorf("dial: %w", x509.UnknownAuthorityError{}), "tls"},
+		{errors.New("boom"), "other"},
+	}
+
+	for _, tt := range tests {
+		if got := errorClass(tt.err); got != tt.want {
+			t.Errorf("errorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestInstrumentedWith_OptionalMetrics(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	opts := InstrumentOpts{InFlight: true, TransportErrors: true, TimeToFirstByte: true}
+	c := InstrumentedWith(reg, opts)(http.DefaultClient)
+	m := newClientMetrics(reg, opts)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.ToFloat64(m.inFlight.WithLabelValues("get")); n != 1 {
+		t.Errorf("Got %v requests in flight before the body has been read, want 1", n)
+	}
+
+	close(release)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if n := testutil.ToFloat64(m.inFlight.WithLabelValues("get")); n != 0 {
+		t.Errorf("Got %v requests in flight after the body has been read, want 0", n)
+	}
+	if n := testutil.CollectAndCount(m.timeToFirstByte); n != 1 {
+		t.Errorf("Got %d time to first byte series, want 1", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ = http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if _, err := c.Do(req); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if n := testutil.ToFloat64(m.transportErrors.WithLabelValues("get", "canceled")); n != 1 {
+		t.Errorf("Got %v canceled transport errors, want 1", n)
+	}
+	if n := testutil.ToFloat64(m.inFlight.WithLabelValues("get")); n != 0 {
+		t.Errorf("Got %v requests in flight after failed request, want 0", n)
+	}
+}
+
+func TestInstrumentedWith_Conflicts(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// The metrics of InstrumentedWithOpts do not collide with those of
+	// InstrumentedWith.
+	reg.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "http_client",
+		Name:      "requests_total",
+	}, []string{"method", "code"}))
+	InstrumentedWith(reg, InstrumentOpts{})
+
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil || !strings.Contains(err.Error(), "same HostLabel and RouteLabel") {
+			t.Errorf("Got panic %v, want explanation of the label conflict", err)
+		}
+	}()
+	InstrumentedWith(reg, InstrumentOpts{HostLabel: true})
+}
+
+func TestInstrumentedWith_ModifiedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	opts := InstrumentOpts{HostLabel: true, InFlight: true}
+	c := Decorate(http.DefaultClient, Scoped(server.URL), InstrumentedWith(reg, opts))
+	m := newClientMetrics(reg, opts)
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "/places", nil)
+		mustDoAndRead(t, c, req)
+	}
+
+	// The labels are those of the request as passed to InstrumentedWith,
+	// before Scoped has set the host.
+	if n := testutil.ToFloat64(m.requests.WithLabelValues("get", "", "200", "")); n != 3 {
+		t.Errorf("Got %v requests, want 3", n)
+	}
+	if n := testutil.CollectAndCount(m.inFlight); n != 1 {
+		t.Errorf("Got %d in-flight series, want 1", n)
+	}
+	if n := testutil.ToFloat64(m.inFlight.WithLabelValues("get", "")); n != 0 {
+		t.Errorf("Got %v requests in flight, want 0", n)
+	}
+}
@@ -7,7 +7,11 @@ import (
 
 // Logged is used to execute a log function after the request has been made.
 // Neither the request body nor the response body will be logged.
-// If the client returned an error it will be logged as well.
+// If the client returned an error it will be logged as well, and so will the
+// route name of the request, if it has one (see WithRoute).
+//
+// logf is called with a message followed by key/value pairs, which suits
+// structured loggers but not log.Printf. Use LoggedSlog for typed attributes.
 func Logged(logf func(string, ...interface{}), trigger func() bool) Decorator {
 	if trigger == nil {
 		trigger = func() bool { return true }
@@ -22,28 +26,36 @@ func Logged(logf func(string, ...interface{}), trigger func() bool) Decorator {
 			defer func(begin time.Time) {
 				took := time.Since(begin)
 
+				var msg string
+				var keyvals []interface{}
 				if err != nil {
-					logf(
-						"cmhttp client error",
+					msg = "cmhttp client error"
+					keyvals = []interface{}{
 						"method", r.Method,
 						"url", r.URL,
 						"proto", r.Proto,
 						"request_content_length", r.Header.Get("Content-Length"),
-						"took_ms", int64(took/time.Millisecond),
+						"took_ms", int64(took / time.Millisecond),
 						"error", err.Error(),
-					)
+					}
 				} else {
-					logf(
-						"cmhttp client response",
+					msg = "cmhttp client response"
+					keyvals = []interface{}{
 						"method", r.Method,
 						"url", r.URL,
 						"proto", r.Proto,
 						"request_content_length", r.Header.Get("Content-Length"),
 						"response_content_length", res.Header.Get("Content-Length"),
 						"response_status", res.Status,
-						"took_ms", int64(took/time.Millisecond),
-					)
+						"took_ms", int64(took / time.Millisecond),
+					}
 				}
+
+				if route := routeFromContext(r.Context()); route != "" {
+					keyvals = append(keyvals, "route", route)
+				}
+
+				logf(msg, keyvals...)
 			}(time.Now())
 
 			res, err = c.Do(r)
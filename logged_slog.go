@@ -0,0 +1,120 @@
+package cmhttp
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// SlogOptions configures LoggedSlog.
+type SlogOptions struct {
+	// SampleRates maps status classes (2 for 2xx, 4 for 4xx, etc.) to the
+	// fraction of responses in that class that are logged, between 0 and 1.
+	// Responses of classes that are not in the map are always logged, and so
+	// are requests that failed with an error.
+	SampleRates map[int]float64
+
+	// Level returns the level of the log record for a request. If nil,
+	// DefaultSlogLevel is used.
+	Level func(*http.Response, error) slog.Level
+}
+
+// DefaultSlogLevel logs errors and 5xx responses at LevelError, 4xx
+// responses at LevelWarn and all other responses at LevelInfo.
+func DefaultSlogLevel(resp *http.Response, err error) slog.Level {
+	switch {
+	case err != nil || resp.StatusCode >= 500:
+		return slog.LevelError
+	case resp.StatusCode >= 400:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// LoggedSlog logs every request to logger after it has been made, with typed
+// attributes for the method, URL, route name (see WithRoute), status code,
+// duration, request and response sizes (if known), the error, the
+// FaultTolerant attempt and the request ID of the Correlation in the request's
+// context. If the response comes from a PhaseTimed client, the durations of
+// the connection phases are logged as well. If logger is nil, slog.Default()
+// is used.
+//
+// Neither the request body nor the response body will be logged.
+func LoggedSlog(logger *slog.Logger, opts SlogOptions) Decorator {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.Level == nil {
+		opts.Level = DefaultSlogLevel
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			begin := time.Now()
+			resp, err := c.Do(r)
+			took := time.Since(begin)
+
+			ctx := r.Context()
+			level := opts.Level(resp, err)
+			if !logger.Enabled(ctx, level) {
+				return resp, err
+			}
+			if err == nil {
+				if rate, ok := opts.SampleRates[resp.StatusCode/100]; ok && rand.Float64() >= rate {
+					return resp, err
+				}
+			}
+
+			method := r.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", method),
+				slog.String("url", r.URL.Redacted()),
+			}
+			if route := routeFromContext(ctx); route != "" {
+				attrs = append(attrs, slog.String("route", route))
+			}
+			if err == nil {
+				attrs = append(attrs, slog.Int("status", resp.StatusCode))
+			}
+			attrs = append(attrs, slog.Duration("duration", took))
+			if r.ContentLength > 0 {
+				attrs = append(attrs, slog.Int64("request_size", r.ContentLength))
+			}
+			if err == nil && resp.ContentLength >= 0 {
+				attrs = append(attrs, slog.Int64("response_size", resp.ContentLength))
+			}
+			if t, ok := ResponsePhaseTimings(resp); ok {
+				attrs = append(attrs, slog.Group("phases",
+					slog.Duration("dns", t.DNS),
+					slog.Duration("connect", t.Connect),
+					slog.Duration("tls", t.TLSHandshake),
+					slog.Duration("wait", t.WaitForFirstByte),
+					slog.Bool("reused", t.ConnReused),
+				))
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			if n := Attempt(ctx); n > 0 {
+				attrs = append(attrs, slog.Int("attempt", n))
+			}
+			if id := CorrelationFromContext(ctx).RequestID; id != "" {
+				attrs = append(attrs, slog.String("request_id", id))
+			}
+
+			msg := "cmhttp client response"
+			if err != nil {
+				msg = "cmhttp client error"
+			}
+			logger.LogAttrs(ctx, level, msg, attrs...)
+
+			return resp, err
+		})
+	}
+}
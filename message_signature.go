@@ -0,0 +1,355 @@
+package cmhttp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MessageSignatureConfig configures MessageSigned and VerifyMessageSignature,
+// which implement HTTP Message Signatures (RFC 9421) with the hmac-sha256
+// algorithm. Client and server must use equal configurations. All fields
+// except Key are optional.
+type MessageSignatureConfig struct {
+	// KeyID is sent as the keyid signature parameter. If set, the server
+	// rejects signatures with a different keyid.
+	KeyID string
+
+	// Key is the shared secret.
+	Key []byte
+
+	// Label identifies the signature in the Signature-Input and Signature
+	// headers. Defaults to "sig1".
+	Label string
+
+	// Components lists the covered components, i.e. derived components such
+	// as "@method" or lowercase header field names. Defaults to "@method",
+	// "@target-uri" and "content-digest". If "content-digest" is covered, the
+	// client sets the Content-Digest header (RFC 9530) and the server
+	// verifies it against the body.
+	Components []string
+
+	// MaxAge is the maximum age of a signature accepted by the server.
+	// Defaults to 5 minutes.
+	MaxAge time.Duration
+}
+
+func (cfg MessageSignatureConfig) withDefaults() MessageSignatureConfig {
+	if cfg.Label == "" {
+		cfg.Label = "sig1"
+	}
+	if len(cfg.Components) == 0 {
+		cfg.Components = []string{"@method", "@target-uri", "content-digest"}
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 5 * time.Minute
+	}
+	return cfg
+}
+
+// MessageSigned signs all requests according to RFC 9421 and sets the
+// Signature-Input and Signature headers.
+func MessageSigned(cfg MessageSignatureConfig) Decorator {
+	cfg = cfg.withDefaults()
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if contains(cfg.Components, "content-digest") {
+				body, err := readAndRestoreBody(r)
+				if err != nil {
+					return nil, err
+				}
+				r.Header.Set("Content-Digest", contentDigest(body))
+			}
+
+			params := signatureParams(cfg.Components, time.Now().Unix(), cfg.KeyID)
+			base, err := signatureBase(clientComponent(r), cfg.Components, params)
+			if err != nil {
+				return nil, err
+			}
+
+			r.Header.Set("Signature-Input", cfg.Label+"="+params)
+			r.Header.Set("Signature", cfg.Label+"=:"+hmacSHA256Base64(cfg.Key, base)+":")
+
+			return c.Do(r)
+		})
+	}
+}
+
+// VerifyMessageSignature returns HTTP server middleware that verifies requests
+// signed by MessageSigned with an equal cfg. Requests without a valid
+// signature that covers all of cfg.Components are rejected with 401
+// Unauthorized.
+func VerifyMessageSignature(cfg MessageSignatureConfig) func(http.Handler) http.Handler {
+	cfg = cfg.withDefaults()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := verifyMessageSignature(cfg, r); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func verifyMessageSignature(cfg MessageSignatureConfig, r *http.Request) error {
+	params, ok := dictionaryMember(r.Header.Get("Signature-Input"), cfg.Label)
+	if !ok {
+		return fmt.Errorf("missing signature input %q", cfg.Label)
+	}
+	signature, ok := dictionaryMember(r.Header.Get("Signature"), cfg.Label)
+	if !ok {
+		return fmt.Errorf("missing signature %q", cfg.Label)
+	}
+
+	components, created, keyID, alg, err := parseSignatureParams(params)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cfg.Components {
+		if !contains(components, c) {
+			return fmt.Errorf("signature does not cover %q", c)
+		}
+	}
+	if cfg.KeyID != "" && keyID != cfg.KeyID {
+		return fmt.Errorf("unknown key %q", keyID)
+	}
+	if alg != "" && alg != "hmac-sha256" {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	if age := time.Since(time.Unix(created, 0)); age > cfg.MaxAge || age < -cfg.MaxAge {
+		return fmt.Errorf("signature creation time is too far from the current time")
+	}
+
+	base, err := signatureBase(serverComponent(r), components, params)
+	if err != nil {
+		return err
+	}
+
+	want := ":" + hmacSHA256Base64(cfg.Key, base) + ":"
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	if contains(components, "content-digest") {
+		body, err := readAndRestoreBody(r)
+		if err != nil {
+			return err
+		}
+		if r.Header.Get("Content-Digest") != contentDigest(body) {
+			return fmt.Errorf("content digest does not match body")
+		}
+	}
+
+	return nil
+}
+
+// signatureParams returns the inner list of covered components and their
+// parameters as used for both the @signature-params component and the
+// Signature-Input header.
+func signatureParams(components []string, created int64, keyID string) string {
+	quoted := make([]string, len(components))
+	for i, c := range components {
+		quoted[i] = strconv.Quote(c)
+	}
+
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(created, 10)
+	if keyID != "" {
+		params += ";keyid=" + strconv.Quote(keyID)
+	}
+
+	return params + `;alg="hmac-sha256"`
+}
+
+// parseSignatureParams parses the output of signatureParams, ignoring unknown
+// parameters.
+func parseSignatureParams(s string) (components []string, created int64, keyID, alg string, err error) {
+	end := strings.IndexByte(s, ')')
+	if !strings.HasPrefix(s, "(") || end < 0 {
+		return nil, 0, "", "", fmt.Errorf("malformed signature input")
+	}
+
+	for _, c := range strings.Fields(s[1:end]) {
+		c, err := strconv.Unquote(c)
+		if err != nil {
+			return nil, 0, "", "", fmt.Errorf("malformed covered component: %w", err)
+		}
+		components = append(components, c)
+	}
+
+	for _, p := range strings.Split(s[end+1:], ";") {
+		name, value, _ := strings.Cut(p, "=")
+		switch name {
+		case "created":
+			created, err = strconv.ParseInt(value, 10, 64)
+		case "keyid":
+			keyID, err = strconv.Unquote(value)
+		case "alg":
+			alg, err = strconv.Unquote(value)
+		}
+		if err != nil {
+			return nil, 0, "", "", fmt.Errorf("malformed signature parameter %q: %w", name, err)
+		}
+	}
+
+	if created == 0 {
+		return nil, 0, "", "", fmt.Errorf("signature has no creation time")
+	}
+
+	return components, created, keyID, alg, nil
+}
+
+// signatureBase builds the signature base of RFC 9421, section 2.5, using
+// value to look up the value of each covered component.
+func signatureBase(value func(string) (string, error), components []string, params string) (string, error) {
+	var buf strings.Builder
+	for _, c := range components {
+		v, err := value(c)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "%q: %s\n", c, v)
+	}
+	fmt.Fprintf(&buf, "%q: %s", "@signature-params", params)
+
+	return buf.String(), nil
+}
+
+// clientComponent returns a function that looks up component values of an
+// outgoing request.
+func clientComponent(r *http.Request) func(string) (string, error) {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	return messageComponent(r, r.URL.Scheme, host, r.URL.String())
+}
+
+// serverComponent returns a function that looks up component values of an
+// incoming request.
+func serverComponent(r *http.Request) func(string) (string, error) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return messageComponent(r, scheme, r.Host, scheme+"://"+r.Host+r.URL.RequestURI())
+}
+
+func messageComponent(r *http.Request, scheme, authority, targetURI string) func(string) (string, error) {
+	return func(name string) (string, error) {
+		switch name {
+		case "@method":
+			return r.Method, nil
+		case "@target-uri":
+			return targetURI, nil
+		case "@authority":
+			return strings.ToLower(authority), nil
+		case "@scheme":
+			return strings.ToLower(scheme), nil
+		case "@request-target":
+			return r.URL.RequestURI(), nil
+		case "@path":
+			if p := r.URL.EscapedPath(); p != "" {
+				return p, nil
+			}
+			return "/", nil
+		case "@query":
+			return "?" + r.URL.RawQuery, nil
+		}
+
+		if strings.HasPrefix(name, "@") {
+			return "", fmt.Errorf("unsupported derived component %q", name)
+		}
+
+		values, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok && name == "host" {
+			values, ok = []string{authority}, true
+		}
+		if !ok {
+			return "", fmt.Errorf("covered header field %q is missing", name)
+		}
+
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.TrimSpace(v)
+		}
+
+		return strings.Join(trimmed, ", "), nil
+	}
+}
+
+// dictionaryMember returns the value of the member with the given name of a
+// structured field dictionary (RFC 8941). It only supports the subset of the
+// syntax used by the Signature and Signature-Input headers.
+func dictionaryMember(dict, name string) (string, bool) {
+	var (
+		start   int
+		quoted  bool
+		nesting int
+	)
+
+	for i := 0; i <= len(dict); i++ {
+		if i < len(dict) {
+			switch dict[i] {
+			case '"':
+				if i == 0 || dict[i-1] != '\\' {
+					quoted = !quoted
+				}
+				continue
+			case '(':
+				if !quoted {
+					nesting++
+				}
+				continue
+			case ')':
+				if !quoted {
+					nesting--
+				}
+				continue
+			case ',':
+				if quoted || nesting > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		member := strings.TrimSpace(dict[start:i])
+		if key, value, ok := strings.Cut(member, "="); ok && key == name {
+			return value, true
+		}
+		start = i + 1
+	}
+
+	return "", false
+}
+
+// contentDigest returns the Content-Digest header value (RFC 9530) for body.
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func hmacSHA256Base64(key []byte, data string) string {
+	return base64.StdEncoding.EncodeToString(hmacSHA256(key, data))
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
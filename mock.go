@@ -0,0 +1,354 @@
+package cmhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestingT is the subset of testing.TB that is used by Mock.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// A Mock is a Client for tests that replies to requests according to
+// expectations, which are registered with On:
+//
+//	mock := &cmhttp.Mock{}
+//	mock.On("GET", "/v1/places/{id}").Reply(200, `{"id": 1}`).Times(2)
+//	mock.On("POST", "/v1/places").WithJSONBody(place).ReplyError(io.ErrUnexpectedEOF)
+//
+//	// use mock as Client
+//
+//	mock.AssertExpectations(t)
+//
+// Requests are matched against the expectations in the order in which they
+// have been registered. Requests that match no expectation fail with an error
+// and are reported by AssertExpectations. The zero value is a Mock without
+// expectations. A Mock is safe for concurrent use.
+type Mock struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	unexpected   []string
+}
+
+// An Expectation describes requests that a Mock expects and how it replies to
+// them. Its methods return the Expectation itself, so that calls can be
+// chained. An Expectation must not be modified after the Mock has been used.
+type Expectation struct {
+	method  string
+	path    string
+	pattern routePattern
+	header  http.Header
+	query   map[string][]string
+	json    interface{}
+	hasJSON bool
+
+	replies []mockReply
+	times   int
+	calls   int
+}
+
+type mockReply struct {
+	status  int
+	header  http.Header
+	body    []byte
+	err     error
+	latency time.Duration
+}
+
+// On registers an expectation for requests with the given method and a path
+// that matches pattern. Path segments of the form {name} in pattern match any
+// single path segment, and a final segment of the form {name...} matches the
+// remainder of the path, like in Routed. On panics if pattern is invalid.
+//
+// Without further configuration, the expectation must be met at least once
+// and replies with 200 OK and an empty body.
+func (m *Mock) On(method, pattern string) *Expectation {
+	p, err := parseRoutePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	e := &Expectation{method: method, path: pattern, pattern: p}
+
+	m.mu.Lock()
+	m.expectations = append(m.expectations, e)
+	m.mu.Unlock()
+
+	return e
+}
+
+// WithHeader restricts e to requests that have the given header value.
+func (e *Expectation) WithHeader(name, value string) *Expectation {
+	if e.header == nil {
+		e.header = make(http.Header)
+	}
+	e.header.Add(name, value)
+	return e
+}
+
+// WithQuery restricts e to requests whose query has the given parameter value.
+func (e *Expectation) WithQuery(name, value string) *Expectation {
+	if e.query == nil {
+		e.query = make(map[string][]string)
+	}
+	e.query[name] = append(e.query[name], value)
+	return e
+}
+
+// WithJSONBody restricts e to requests whose body is JSON equivalent to v.
+// If v is a string or []byte, it is interpreted as JSON document, otherwise it
+// is marshaled. The order of object keys and whitespace are ignored.
+func (e *Expectation) WithJSONBody(v interface{}) *Expectation {
+	doc, err := jsonDocument(v)
+	if err != nil {
+		panic(fmt.Errorf("cmhttp.Mock: invalid JSON body: %w", err))
+	}
+	e.json, e.hasJSON = doc, true
+	return e
+}
+
+// Reply adds a response with the given status and body to e. If body is a
+// string or []byte, it is used as is; if it is nil, the body is empty;
+// otherwise body is marshaled as JSON and the Content-Type header is set to
+// application/json.
+//
+// If Reply, ReplyError or both are called multiple times, matching requests
+// get the replies in order, and the last reply is repeated.
+func (e *Expectation) Reply(status int, body interface{}) *Expectation {
+	r := mockReply{status: status, header: make(http.Header)}
+
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r.body = []byte(b)
+	case []byte:
+		r.body = b
+	default:
+		var err error
+		if r.body, err = json.Marshal(b); err != nil {
+			panic(fmt.Errorf("cmhttp.Mock: marshaling reply body: %w", err))
+		}
+		r.header.Set("Content-Type", "application/json")
+	}
+
+	e.replies = append(e.replies, r)
+	return e
+}
+
+// ReplyHeader sets a header of the last reply added with Reply. It panics if
+// the last reply was added with ReplyError.
+func (e *Expectation) ReplyHeader(name, value string) *Expectation {
+	if len(e.replies) == 0 {
+		e.Reply(http.StatusOK, nil)
+	}
+	if e.replies[len(e.replies)-1].err != nil {
+		panic(fmt.Sprintf("cmhttp.Mock: ReplyHeader(%q) after ReplyError: error replies have no headers", name))
+	}
+	e.replies[len(e.replies)-1].header.Add(name, value)
+	return e
+}
+
+// ReplyError adds a reply to e that fails with err. err must not be nil.
+func (e *Expectation) ReplyError(err error) *Expectation {
+	e.replies = append(e.replies, mockReply{header: make(http.Header), err: err})
+	return e
+}
+
+// After delays the last reply by d, or until the request's context is done.
+func (e *Expectation) After(d time.Duration) *Expectation {
+	if len(e.replies) == 0 {
+		e.Reply(http.StatusOK, nil)
+	}
+	e.replies[len(e.replies)-1].latency = d
+	return e
+}
+
+// Times makes e match exactly n requests. Further requests are matched
+// against the following expectations.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Once is short for Times(1).
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.path
+}
+
+// Do implements Client.
+func (m *Mock) Do(r *http.Request) (*http.Response, error) {
+	body, err := readAndRestoreBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := m.match(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply.latency > 0 {
+		t := time.NewTimer(reply.latency)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+
+	if reply.err != nil {
+		return nil, reply.err
+	}
+
+	return newResponse(r, reply.status, reply.header.Clone(), reply.body), nil
+}
+
+func (m *Mock) match(r *http.Request, body []byte) (mockReply, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var closest *Expectation
+	var closestDiffs []string
+	for _, e := range m.expectations {
+		diffs := e.differences(r, body)
+		if len(diffs) == 0 && (e.times == 0 || e.calls < e.times) {
+			e.calls++
+			if len(e.replies) == 0 {
+				return mockReply{status: http.StatusOK}, nil
+			}
+			n := e.calls - 1
+			if n >= len(e.replies) {
+				n = len(e.replies) - 1
+			}
+			return e.replies[n], nil
+		}
+		if len(diffs) == 0 {
+			diffs = []string{fmt.Sprintf("expected %d times, already called %d times", e.times, e.calls)}
+		}
+		if closest == nil || len(diffs) < len(closestDiffs) {
+			closest, closestDiffs = e, diffs
+		}
+	}
+
+	msg := fmt.Sprintf("unexpected request %s %s", requestMethod(r), r.URL.RequestURI())
+	if closest != nil {
+		msg += fmt.Sprintf("; closest expectation %s differs:\n\t%s", closest, strings.Join(closestDiffs, "\n\t"))
+	}
+	m.unexpected = append(m.unexpected, msg)
+
+	return mockReply{}, fmt.Errorf("cmhttp.Mock: %s", msg)
+}
+
+// differences returns how r differs from the requests that e expects.
+func (e *Expectation) differences(r *http.Request, body []byte) []string {
+	var diffs []string
+
+	if method := requestMethod(r); method != e.method {
+		diffs = append(diffs, fmt.Sprintf("method: got %s, want %s", method, e.method))
+	}
+	if !e.pattern.matches(r) {
+		diffs = append(diffs, fmt.Sprintf("path: %s does not match %s", r.URL.EscapedPath(), e.path))
+	}
+
+	for _, name := range sortedKeys(e.header) {
+		if got, want := r.Header.Values(name), e.header[name]; !reflect.DeepEqual(got, want) {
+			diffs = append(diffs, fmt.Sprintf("header %s: got %q, want %q", name, got, want))
+		}
+	}
+
+	query := r.URL.Query()
+	for _, name := range sortedKeys(e.query) {
+		if got, want := query[name], e.query[name]; !reflect.DeepEqual(got, want) {
+			diffs = append(diffs, fmt.Sprintf("query parameter %s: got %q, want %q", name, got, want))
+		}
+	}
+
+	if e.hasJSON {
+		got, err := jsonDocument(body)
+		if err != nil {
+			diffs = append(diffs, fmt.Sprintf("body: invalid JSON %q: %v", body, err))
+		} else if !reflect.DeepEqual(got, e.json) {
+			want, _ := json.Marshal(e.json)
+			normalized, _ := json.Marshal(got)
+			diffs = append(diffs, fmt.Sprintf("body: got %s, want %s", normalized, want))
+		}
+	}
+
+	return diffs
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AssertExpectations reports expectations that have not been met and requests
+// that did not match any expectation as errors to t. It returns whether there
+// were no errors.
+func (m *Mock) AssertExpectations(t TestingT) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ok := true
+	for _, e := range m.expectations {
+		switch {
+		case e.times == 0 && e.calls == 0:
+			t.Errorf("cmhttp.Mock: expected %s to be called at least once, but it was not called", e)
+		case e.times > 0 && e.calls != e.times:
+			t.Errorf("cmhttp.Mock: expected %s to be called %d times, but it was called %d times", e, e.times, e.calls)
+		default:
+			continue
+		}
+		ok = false
+	}
+
+	for _, msg := range m.unexpected {
+		t.Errorf("cmhttp.Mock: %s", msg)
+		ok = false
+	}
+
+	return ok
+}
+
+// jsonDocument returns the JSON document v, which is either a string or
+// []byte containing JSON or a value to be marshaled, in its generic
+// representation.
+func jsonDocument(v interface{}) (interface{}, error) {
+	var doc []byte
+	switch v := v.(type) {
+	case string:
+		doc = []byte(v)
+	case []byte:
+		doc = v
+	default:
+		var err error
+		if doc, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+
+	var generic interface{}
+	err := json.Unmarshal(doc, &generic)
+	return generic, err
+}
@@ -0,0 +1,129 @@
+package cmhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMock(t *testing.T) {
+	mock := &Mock{}
+	mock.On("GET", "/v1/places/{id}").
+		Reply(200, map[string]int{"id": 1}).
+		Reply(404, "not found").ReplyHeader("X-Reason", "gone").
+		Times(2)
+	mock.On("GET", "/v1/places/{id}").ReplyError(io.ErrUnexpectedEOF)
+	mock.On("POST", "/v1/places").
+		WithHeader("Authorization", "Bearer token").
+		WithQuery("dry_run", "true").
+		WithJSONBody(`{"name": "Berlin", "tags": ["a", "b"]}`).
+		Reply(201, nil)
+
+	do := func(method, url, body string) (*http.Response, error) {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		return mock.Do(req)
+	}
+
+	resp, err := do("GET", "http://example.com/v1/places/1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != `{"id":1}` || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("First reply: %d %s %v", resp.StatusCode, body, resp.Header)
+	}
+
+	resp, err = do("GET", "http://example.com/v1/places/2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 404 || string(body) != "not found" || resp.Header.Get("X-Reason") != "gone" {
+		t.Errorf("Second reply: %d %s %v", resp.StatusCode, body, resp.Header)
+	}
+
+	if _, err := do("GET", "http://example.com/v1/places/3", ""); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Third reply: got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	resp, err = do("POST", "http://example.com/v1/places?dry_run=true", `{"tags":["a","b"],"name":"Berlin"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 201 {
+		t.Errorf("POST reply: %d", resp.StatusCode)
+	}
+
+	if !mock.AssertExpectations(t) {
+		t.Error("AssertExpectations() = false")
+	}
+}
+
+func TestMock_AssertExpectations(t *testing.T) {
+	mock := &Mock{}
+	mock.On("GET", "/a").Once()
+	mock.On("POST", "/places").WithJSONBody(map[string]string{"name": "Berlin"})
+	mock.On("DELETE", "/b").Times(2)
+
+	req, _ := http.NewRequest("DELETE", "http://example.com/b", nil)
+	mock.Do(req)
+
+	req, _ = http.NewRequest("POST", "http://example.com/places", strings.NewReader(`{"name":"Hamburg"}`))
+	if _, err := mock.Do(req); err == nil {
+		t.Error("Unexpected request did not fail")
+	}
+
+	rec := &recordingT{}
+	if mock.AssertExpectations(rec) {
+		t.Error("AssertExpectations() = true")
+	}
+
+	want := []string{
+		"cmhttp.Mock: expected GET /a to be called 1 times, but it was called 0 times",
+		"cmhttp.Mock: expected POST /places to be called at least once, but it was not called",
+		"cmhttp.Mock: expected DELETE /b to be called 2 times, but it was called 1 times",
+		"cmhttp.Mock: unexpected request POST /places; closest expectation POST /places differs:\n" +
+			"\tbody: got {\"name\":\"Hamburg\"}, want {\"name\":\"Berlin\"}",
+	}
+	if strings.Join(rec.errors, "\n---\n") != strings.Join(want, "\n---\n") {
+		t.Errorf("Reported errors:\n%s\n\nwant:\n%s", strings.Join(rec.errors, "\n---\n"), strings.Join(want, "\n---\n"))
+	}
+}
+
+func TestMock_Latency(t *testing.T) {
+	mock := &Mock{}
+	mock.On("GET", "/slow").Reply(200, nil).After(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/slow", nil)
+	if _, err := mock.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMock_ReplyHeaderAfterReplyError(t *testing.T) {
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "ReplyHeader(\"X-Test\") after ReplyError") {
+			t.Errorf("Got panic %q, want explanation", msg)
+		}
+	}()
+
+	(&Mock{}).On("GET", "/").ReplyError(errors.New("boom")).ReplyHeader("X-Test", "1")
+}
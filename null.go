@@ -3,7 +3,7 @@ package cmhttp
 import "net/http"
 
 // Null always returns a 204 No Content response without ever opening a
-// network connection.
+// network connection. Use Stubbed for other responses.
 func Null() Decorator {
 	return func(c Client) Client {
 		return ClientFunc(func(*http.Request) (*http.Response, error) {
@@ -0,0 +1,250 @@
+package cmhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oauth2ExpiryDelta is how long before their actual expiry tokens are
+	// considered expired, to account for clock skew and request latency.
+	oauth2ExpiryDelta = 10 * time.Second
+
+	// oauth2RefreshWindow is how long before their expiry tokens are
+	// refreshed in the background while still being used. Tokens with a
+	// lifetime of less than twice this window are refreshed after half of
+	// their lifetime instead.
+	oauth2RefreshWindow = time.Minute
+
+	// oauth2FetchTimeout limits how long a token request may take. Token
+	// requests are shared by all waiting requests and don't use their
+	// contexts' deadlines.
+	oauth2FetchTimeout = 30 * time.Second
+)
+
+var oauth2Now = time.Now
+
+// OAuth2ClientCredentials authenticates all requests with an access token
+// obtained via the OAuth2 client credentials grant (RFC 6749, section 4.4)
+// from tokenURL. The token is sent in the Authorization header as a bearer
+// token.
+//
+// Tokens are requested with the decorated Client and cached until shortly
+// before they expire. A new token is fetched in the background when the
+// current one is about to expire, so that requests are not delayed. At most
+// one token request is in flight at any time.
+//
+// If a request is rejected with 401 Unauthorized, it is retried once with a
+// freshly fetched token. Requests with a body are only retried if the body
+// can be replayed, i.e. if Request.GetBody is set.
+func OAuth2ClientCredentials(tokenURL, clientID, secret string, scopes []string) Decorator {
+	return func(c Client) Client {
+		tokens := &oauth2TokenCache{
+			client:   c,
+			tokenURL: tokenURL,
+			clientID: clientID,
+			secret:   secret,
+			scopes:   scopes,
+		}
+
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			token, err := tokens.get(r.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			r.Header.Set("Authorization", "Bearer "+token.Value)
+			resp, err := c.Do(r)
+			if err != nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+
+			if !rewindBody(r) {
+				return resp, err
+			}
+
+			DrainClose(resp.Body)
+			tokens.invalidate(token)
+
+			token, err = tokens.get(r.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			r.Header.Set("Authorization", "Bearer "+token.Value)
+			return c.Do(r)
+		})
+	}
+}
+
+// rewindBody prepares r to be sent again and reports whether that is possible.
+func rewindBody(r *http.Request) bool {
+	if r.Body == nil || r.Body == http.NoBody {
+		return true
+	}
+	if r.GetBody == nil {
+		return false
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return false
+	}
+
+	r.Body = body
+	return true
+}
+
+// oauth2TokenCache fetches and caches client credentials tokens.
+type oauth2TokenCache struct {
+	client   Client
+	tokenURL string
+	clientID string
+	secret   string
+	scopes   []string
+
+	mu         sync.Mutex
+	token      Token
+	refreshAt  time.Time     // when to refresh token in the background; zero if never
+	refreshing chan struct{} // non-nil while a token request is in flight
+	err        error         // result of the last token request
+}
+
+// get returns a valid token, fetching a new one if necessary.
+func (tc *oauth2TokenCache) get(ctx context.Context) (Token, error) {
+	tc.mu.Lock()
+
+	if tc.token.Value != "" && !tc.token.expiresWithin(oauth2ExpiryDelta) {
+		token := tc.token
+		if tc.refreshing == nil && !tc.refreshAt.IsZero() && oauth2Now().After(tc.refreshAt) {
+			go tc.refresh(context.Background(), tc.startRefresh())
+		}
+		tc.mu.Unlock()
+		return token, nil
+	}
+
+	// The token request is shared by all callers, so it must not be canceled
+	// with the context of the one that happens to start it.
+	if tc.refreshing == nil {
+		go tc.refresh(context.WithoutCancel(ctx), tc.startRefresh())
+	}
+
+	done := tc.refreshing
+	tc.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return Token{}, ctx.Err()
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.err != nil {
+		return Token{}, tc.err
+	}
+	if tc.token.Value == "" {
+		return Token{}, fmt.Errorf("fetching OAuth2 token: token was invalidated concurrently")
+	}
+
+	return tc.token, nil
+}
+
+// invalidate discards t unless it has already been replaced by another token.
+func (tc *oauth2TokenCache) invalidate(t Token) {
+	tc.mu.Lock()
+	if tc.token == t {
+		tc.token = Token{}
+	}
+	tc.mu.Unlock()
+}
+
+// startRefresh marks a token request as in flight. tc.mu must be held.
+func (tc *oauth2TokenCache) startRefresh() chan struct{} {
+	tc.refreshing = make(chan struct{})
+	return tc.refreshing
+}
+
+// refresh fetches a new token and closes done when it is finished. tc.mu must
+// not be held.
+func (tc *oauth2TokenCache) refresh(ctx context.Context, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(ctx, oauth2FetchTimeout)
+	defer cancel()
+
+	issued := oauth2Now()
+	token, err := tc.fetch(ctx)
+
+	tc.mu.Lock()
+	if err == nil {
+		tc.token = token
+		tc.refreshAt = time.Time{}
+		if !token.Expiry.IsZero() {
+			window := oauth2RefreshWindow
+			if lifetime := token.Expiry.Sub(issued); lifetime/2 < window {
+				window = lifetime / 2
+			}
+			tc.refreshAt = token.Expiry.Add(-window)
+		}
+	}
+	tc.err = err
+	tc.refreshing = nil
+	close(done)
+	tc.mu.Unlock()
+}
+
+func (tc *oauth2TokenCache) fetch(ctx context.Context) (Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(tc.scopes) > 0 {
+		form.Set("scope", strings.Join(tc.scopes, " "))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tc.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(tc.clientID), url.QueryEscape(tc.secret))
+
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("fetching OAuth2 token: %w", err)
+	}
+	defer DrainClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return Token{}, fmt.Errorf("fetching OAuth2 token: unexpected response status %q: %s", resp.Status, body)
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return Token{}, fmt.Errorf("fetching OAuth2 token: decoding response: %w", err)
+	}
+
+	if payload.AccessToken == "" {
+		return Token{}, fmt.Errorf("fetching OAuth2 token: response contains no access token")
+	}
+	if payload.TokenType != "" && !strings.EqualFold(payload.TokenType, "bearer") {
+		return Token{}, fmt.Errorf("fetching OAuth2 token: unsupported token type %q", payload.TokenType)
+	}
+
+	token := Token{Value: payload.AccessToken}
+	if payload.ExpiresIn > 0 {
+		token.Expiry = oauth2Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,260 @@
+package cmhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func oauth2TestServer(t *testing.T, expiresIn int, tokenRequests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(tokenRequests, 1)
+
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" {
+			t.Errorf("Unexpected grant_type %q", r.PostForm.Get("grant_type"))
+		}
+		if r.PostForm.Get("scope") != "read write" {
+			t.Errorf("Unexpected scope %q", r.PostForm.Get("scope"))
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := oauth2TestServer(t, 3600, &tokenRequests)
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := OAuth2ClientCredentials(tokenServer.URL, "client", "s3cret", []string{"read", "write"})(http.DefaultClient)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("GET", server.URL, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusAccepted {
+				t.Errorf("Unexpected response status %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Errorf("Token endpoint received %d requests, want 1", n)
+	}
+}
+
+func TestOAuth2ClientCredentials_RetriesUnauthorized(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := oauth2TestServer(t, 3600, &tokenRequests)
+	defer tokenServer.Close()
+
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := OAuth2ClientCredentials(tokenServer.URL, "client", "s3cret", []string{"read", "write"})(http.DefaultClient)
+
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader("payload"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Unexpected response status %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&tokenRequests); n != 2 {
+		t.Errorf("Token endpoint received %d requests, want 2", n)
+	}
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Errorf("Server received bodies %q, want the payload twice", bodies)
+	}
+}
+
+func TestOAuth2ClientCredentials_RefreshesExpiringTokens(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := oauth2TestServer(t, 3600, &tokenRequests)
+	defer tokenServer.Close()
+
+	client := newOAuth2TestClient(tokenServer.URL)
+
+	if got := client.doUntil(t, "", 1); got != "Bearer token-1" {
+		t.Fatalf("First request has Authorization %q", got)
+	}
+
+	// The token is now within the refresh window, but still valid.
+	defer setOAuth2Now(time.Now().Add(time.Hour - 30*time.Second))()
+
+	if got := client.doUntil(t, "", 1); got != "Bearer token-1" {
+		t.Errorf("Request within the refresh window has Authorization %q, want the current token", got)
+	}
+	client.doUntil(t, "Bearer token-2", 50)
+
+	if n := atomic.LoadInt32(&tokenRequests); n != 2 {
+		t.Errorf("Token endpoint received %d requests, want 2", n)
+	}
+}
+
+func TestOAuth2ClientCredentials_ShortLivedTokens(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := oauth2TestServer(t, 30, &tokenRequests)
+	defer tokenServer.Close()
+
+	client := newOAuth2TestClient(tokenServer.URL)
+
+	for i := 0; i < 5; i++ {
+		client.doUntil(t, "", 1)
+	}
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Errorf("Token endpoint received %d requests, want 1", n)
+	}
+
+	// Half of the token's lifetime has passed.
+	defer setOAuth2Now(time.Now().Add(16 * time.Second))()
+
+	client.doUntil(t, "Bearer token-2", 50)
+	if n := atomic.LoadInt32(&tokenRequests); n != 2 {
+		t.Errorf("Token endpoint received %d requests, want 2", n)
+	}
+}
+
+// oauth2TestClient sends token requests to a real token server and records the
+// Authorization header of all other requests.
+type oauth2TestClient struct {
+	Client
+	authorization string
+}
+
+func newOAuth2TestClient(tokenURL string) *oauth2TestClient {
+	c := &oauth2TestClient{}
+	c.Client = OAuth2ClientCredentials(tokenURL, "client", "s3cret", []string{"read", "write"})(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.String() == tokenURL {
+			return http.DefaultClient.Do(r)
+		}
+		c.authorization = r.Header.Get("Authorization")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+	return c
+}
+
+// doUntil sends requests until one of them has the Authorization header want,
+// but at most n requests. It returns the Authorization header of the last
+// request.
+func (c *oauth2TestClient) doUntil(t *testing.T, want string, n int) string {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		if _, err := c.Do(req); err != nil {
+			t.Fatal(err)
+		}
+		if c.authorization == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if want != "" && c.authorization != want {
+		t.Fatalf("Request has Authorization %q, want %q", c.authorization, want)
+	}
+	return c.authorization
+}
+
+func TestOAuth2ClientCredentials_SharedFetchIgnoresCallerDeadline(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"access_token":"token-1","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	client := newOAuth2TestClient(tokenServer.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	impatient := make(chan error)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+		_, err := client.Do(req)
+		impatient <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond) // let the first request start the token request
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Errorf("Request without deadline failed: %v", err)
+	}
+	if client.authorization != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want %q", client.authorization, "Bearer token-1")
+	}
+
+	if err := <-impatient; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request with deadline failed with %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOAuth2ClientCredentials_TokenError(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := oauth2TestServer(t, 3600, &tokenRequests)
+	defer tokenServer.Close()
+
+	client := OAuth2ClientCredentials(tokenServer.URL, "client", "wrong", nil)(http.DefaultClient)
+
+	req, _ := http.NewRequest("GET", "http://example.invalid", nil)
+	_, err := client.Do(req)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected error about unexpected status 401, got %v", err)
+	}
+}
+
+func setOAuth2Now(t time.Time) (reset func()) {
+	oauth2Now = func() time.Time { return t }
+	return func() { oauth2Now = time.Now }
+}
@@ -0,0 +1,213 @@
+package cmhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PhaseTimings are the durations of the phases of a request. Phases that were
+// skipped, for instance DNS, Connect and TLSHandshake for reused connections,
+// are zero.
+type PhaseTimings struct {
+	DNS              time.Duration
+	Connect          time.Duration
+	TLSHandshake     time.Duration
+	WaitForFirstByte time.Duration // after the request has been written
+	BodyTransfer     time.Duration // until the body has been read or closed
+
+	ConnReused bool
+}
+
+type phaseRecorderKey struct{}
+
+// ResponsePhaseTimings returns the phase timings of a request made by a
+// PhaseTimed client. BodyTransfer is only known after the response body has
+// been read completely or closed. The second result is false if resp was not
+// returned by a PhaseTimed client.
+func ResponsePhaseTimings(resp *http.Response) (PhaseTimings, bool) {
+	if resp == nil || resp.Request == nil {
+		return PhaseTimings{}, false
+	}
+
+	rec, ok := resp.Request.Context().Value(phaseRecorderKey{}).(*phaseRecorder)
+	if !ok {
+		return PhaseTimings{}, false
+	}
+
+	return rec.timings(), true
+}
+
+// PhaseTimed traces the phases of all requests with httptrace and records
+// their durations. The timings of a request can be retrieved from its response
+// with ResponsePhaseTimings.
+//
+// Additionally, the durations are tracked as a histogram vector partitioned
+// by phase (label name "phase", one of "dns", "connect", "tls", "wait" and
+// "transfer") and connection reuse (label name "reused"), and registered with
+// reg. If reg is nil, the default Registerer is used. If the Name and Help
+// fields of opts are empty, they are set to "request_phase_duration_seconds"
+// and a generic description.
+func PhaseTimed(reg prometheus.Registerer, opts prometheus.HistogramOpts) Decorator {
+	if opts.Name == "" {
+		opts.Name = "request_phase_duration_seconds"
+	}
+	if opts.Help == "" {
+		opts.Help = "The duration of the phases of HTTP requests in seconds."
+	}
+
+	durations := mustRegisterOrGet(reg,
+		prometheus.NewHistogramVec(opts, []string{"phase", "reused"}),
+	).(*prometheus.HistogramVec)
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			rec := &phaseRecorder{}
+			r = r.WithContext(rec.context(r.Context()))
+
+			resp, err := c.Do(r)
+			if err != nil {
+				return resp, err
+			}
+
+			t := rec.timings()
+			reused := strconv.FormatBool(t.ConnReused)
+			for phase, d := range map[string]time.Duration{
+				"dns":     t.DNS,
+				"connect": t.Connect,
+				"tls":     t.TLSHandshake,
+				"wait":    t.WaitForFirstByte,
+			} {
+				if d > 0 {
+					durations.WithLabelValues(phase, reused).Observe(d.Seconds())
+				}
+			}
+
+			resp.Body = rec.wrapBody(resp.Body, func(d time.Duration) {
+				durations.WithLabelValues("transfer", reused).Observe(d.Seconds())
+			})
+
+			return resp, err
+		})
+	}
+}
+
+// phaseRecorder records the points in time at which the phases of a request
+// start and end.
+type phaseRecorder struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	gotConn      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	bodyDone     time.Time
+	reused       bool
+}
+
+// context returns a copy of ctx that makes the transport report to rec.
+func (rec *phaseRecorder) context(ctx context.Context) context.Context {
+	set := func(t *time.Time) {
+		rec.mu.Lock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+		rec.mu.Unlock()
+	}
+
+	ctx = context.WithValue(ctx, phaseRecorderKey{}, rec)
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { set(&rec.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { set(&rec.dnsDone) },
+		ConnectStart:      func(string, string) { set(&rec.connectStart) },
+		ConnectDone:       func(string, string, error) { set(&rec.connectDone) },
+		TLSHandshakeStart: func() { set(&rec.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { set(&rec.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			set(&rec.gotConn)
+			rec.mu.Lock()
+			rec.reused = info.Reused
+			rec.mu.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&rec.wroteRequest) },
+		GotFirstResponseByte: func() { set(&rec.firstByte) },
+	})
+}
+
+// wrapBody returns a body that records when body has been read completely or
+// has been closed, and calls done with the transfer duration once.
+func (rec *phaseRecorder) wrapBody(body io.ReadCloser, done func(time.Duration)) io.ReadCloser {
+	return &phaseRecordingBody{ReadCloser: body, rec: rec, done: done}
+}
+
+func (rec *phaseRecorder) finishBody() (time.Duration, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !rec.bodyDone.IsZero() || rec.firstByte.IsZero() {
+		return 0, false
+	}
+
+	rec.bodyDone = time.Now()
+	return rec.bodyDone.Sub(rec.firstByte), true
+}
+
+func (rec *phaseRecorder) timings() PhaseTimings {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return PhaseTimings{
+		DNS:              between(rec.dnsStart, rec.dnsDone),
+		Connect:          between(rec.connectStart, rec.connectDone),
+		TLSHandshake:     between(rec.tlsStart, rec.tlsDone),
+		WaitForFirstByte: between(rec.wroteRequest, rec.firstByte),
+		BodyTransfer:     between(rec.firstByte, rec.bodyDone),
+		ConnReused:       rec.reused,
+	}
+}
+
+// between returns the duration from start to end, or zero if either of them
+// is unknown.
+func between(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+type phaseRecordingBody struct {
+	io.ReadCloser
+	rec  *phaseRecorder
+	done func(time.Duration)
+}
+
+func (b *phaseRecordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *phaseRecordingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *phaseRecordingBody) finish() {
+	if d, ok := b.rec.finishBody(); ok && b.done != nil {
+		b.done(d)
+	}
+}
@@ -0,0 +1,81 @@
+package cmhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestPhaseTimed(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		time.Sleep(10 * time.Millisecond)
+		io.WriteString(w, "hello")
+	}))
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	client := PhaseTimed(reg, prometheus.HistogramOpts{})(server.Client())
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		timings, ok := ResponsePhaseTimings(resp)
+		if !ok {
+			t.Fatal("Response has no phase timings")
+		}
+
+		if timings.ConnReused != (i > 0) {
+			t.Errorf("Request %d: ConnReused = %t", i, timings.ConnReused)
+		}
+		if i == 0 && (timings.Connect <= 0 || timings.TLSHandshake <= 0) {
+			t.Errorf("Request %d: missing connect or TLS handshake duration: %+v", i, timings)
+		}
+		if i > 0 && (timings.Connect != 0 || timings.TLSHandshake != 0) {
+			t.Errorf("Request %d: unexpected connect or TLS handshake duration on reused connection: %+v", i, timings)
+		}
+		if timings.WaitForFirstByte < 20*time.Millisecond {
+			t.Errorf("Request %d: WaitForFirstByte = %s, want at least 20ms", i, timings.WaitForFirstByte)
+		}
+		if timings.BodyTransfer < 10*time.Millisecond {
+			t.Errorf("Request %d: BodyTransfer = %s, want at least 10ms", i, timings.BodyTransfer)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 {
+		t.Fatalf("Got %d metric families, want 1", len(families))
+	}
+
+	counts := map[string]uint64{}
+	for _, m := range families[0].Metric {
+		for _, l := range m.Label {
+			if l.GetName() == "phase" {
+				counts[l.GetValue()] += m.Histogram.GetSampleCount()
+			}
+		}
+	}
+
+	want := map[string]uint64{"connect": 1, "tls": 1, "wait": 2, "transfer": 2}
+	for phase, n := range want {
+		if counts[phase] != n {
+			t.Errorf("Phase %q has %d observations, want %d", phase, counts[phase], n)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package cmhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// A RecorderMode determines whether a Recorder makes real requests.
+type RecorderMode int
+
+const (
+	// ModeReplay serves all requests from the cassette and fails requests
+	// that do not match a recorded interaction.
+	ModeReplay RecorderMode = iota
+
+	// ModeRecord makes all requests with the real client and records them,
+	// replacing the interactions in the cassette when it is saved.
+	ModeRecord
+
+	// ModeRecordMissing serves requests from the cassette if possible and
+	// makes and records all other requests with the real client.
+	ModeRecordMissing
+)
+
+// A RequestMatcher reports whether a request, whose body is given separately,
+// matches a recorded request.
+type RequestMatcher func(r *http.Request, body []byte, recorded RecordedRequest) bool
+
+// MatchMethod matches requests with the same method.
+func MatchMethod(r *http.Request, _ []byte, recorded RecordedRequest) bool {
+	return requestMethod(r) == recorded.Method
+}
+
+// MatchURL matches requests with the same URL. URLs are compared as they are
+// stored in the cassette, i.e. with passwords and the query parameters of
+// RecorderOptions.Redaction redacted.
+func MatchURL(r *http.Request, _ []byte, recorded RecordedRequest) bool {
+	return r.URL.Redacted() == recorded.URL
+}
+
+// MatchBody matches requests with the same body.
+func MatchBody(r *http.Request, body []byte, recorded RecordedRequest) bool {
+	return bytes.Equal(body, recorded.body())
+}
+
+// MatchHeaders returns a RequestMatcher that matches requests with the same
+// values of the given headers.
+func MatchHeaders(names ...string) RequestMatcher {
+	return func(r *http.Request, _ []byte, recorded RecordedRequest) bool {
+		for _, name := range names {
+			if strings.Join(r.Header.Values(name), "\n") != strings.Join(recorded.Header.Values(name), "\n") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAll returns a RequestMatcher that matches requests that match all
+// given matchers.
+func MatchAll(matchers ...RequestMatcher) RequestMatcher {
+	return func(r *http.Request, body []byte, recorded RecordedRequest) bool {
+		for _, m := range matchers {
+			if !m(r, body, recorded) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// RecorderOptions configures a Recorder.
+type RecorderOptions struct {
+	Mode RecorderMode
+
+	// Match decides whether a request matches a recorded one. If nil,
+	// requests are matched by method and URL.
+	Match RequestMatcher
+
+	// Redaction scrubs headers, query parameters and JSON bodies of
+	// interactions before they are saved. JSON bodies are redacted regardless
+	// of their content type, and left out if they cannot be redacted. Note
+	// that requests are matched against the scrubbed interactions.
+	Redaction RedactionPolicy
+
+	// Scrub is called for every new interaction after Redaction has been
+	// applied, to remove other secrets, e.g. from URLs or text bodies.
+	Scrub func(*Interaction)
+}
+
+// An Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// A RecordedRequest is the part of a request that is stored in a cassette.
+// The body is stored as text if it is valid UTF-8, or base64 encoded
+// otherwise.
+type RecordedRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+}
+
+// A RecordedResponse is the part of a response that is stored in a cassette.
+type RecordedResponse struct {
+	Status     int         `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 string      `json:"body_base64,omitempty"`
+}
+
+func (r RecordedRequest) body() []byte {
+	return decodeRecordedBody(r.Body, r.BodyBase64)
+}
+
+func (r RecordedResponse) body() []byte {
+	return decodeRecordedBody(r.Body, r.BodyBase64)
+}
+
+func decodeRecordedBody(text, b64 string) []byte {
+	if b64 != "" {
+		body, _ := base64.StdEncoding.DecodeString(b64)
+		return body
+	}
+	return []byte(text)
+}
+
+// encodeRecordedBody returns body as text, or base64 encoded if it is not
+// valid UTF-8.
+func encodeRecordedBody(body []byte) (text, b64 string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+// A Recorder records interactions with a real client into a cassette file
+// and replays them, so that tests can run without network access. The
+// cassette is a JSON Lines file with one Interaction per line. Use its Record
+// method as a Decorator:
+//
+//	rec, err := cmhttp.NewRecorder("testdata/places.jsonl", cmhttp.RecorderOptions{
+//		Mode: cmhttp.ModeRecordMissing,
+//	})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer rec.Save()
+//
+//	client := cmhttp.Decorate(http.DefaultClient, rec.Record)
+//
+// Each recorded interaction is replayed once, in order of recording, after
+// which the last matching interaction is replayed repeatedly.
+type Recorder struct {
+	path string
+	opts RecorderOptions
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+	dirty        bool
+}
+
+// NewRecorder returns a Recorder that uses the cassette at path. The cassette
+// is loaded unless opts.Mode is ModeRecord. It must exist in ModeReplay.
+func NewRecorder(path string, opts RecorderOptions) (*Recorder, error) {
+	if opts.Match == nil {
+		opts.Match = MatchAll(MatchMethod, MatchURL)
+	}
+
+	rec := &Recorder{path: path, opts: opts}
+	if opts.Mode == ModeRecord {
+		return rec, nil
+	}
+
+	interactions, err := loadCassette(path)
+	if errors.Is(err, os.ErrNotExist) && opts.Mode == ModeRecordMissing {
+		return rec, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rec.interactions = interactions
+	rec.used = make([]bool, len(interactions))
+
+	return rec, nil
+}
+
+func loadCassette(path string) ([]Interaction, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var interactions []Interaction
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var i Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		interactions = append(interactions, i)
+	}
+
+	return interactions, scanner.Err()
+}
+
+// Record is a Decorator that replays or records all requests made with c,
+// depending on the Recorder's mode.
+func (rec *Recorder) Record(c Client) Client {
+	return ClientFunc(func(r *http.Request) (*http.Response, error) {
+		body, err := readAndRestoreBody(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if rec.opts.Mode != ModeRecord {
+			if i, ok := rec.find(r, body); ok {
+				return replayResponse(r, i.Response), nil
+			}
+			if rec.opts.Mode == ModeReplay {
+				return nil, rec.noMatchError(r, body)
+			}
+		}
+
+		resp, err := c.Do(r)
+		if err != nil {
+			return resp, err
+		}
+
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+		rec.add(rec.newInteraction(r, body, resp, respBody))
+
+		return resp, nil
+	})
+}
+
+// Save writes the cassette if interactions have been recorded.
+func (rec *Recorder) Save() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !rec.dirty {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, i := range rec.interactions {
+		if err := enc.Encode(i); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(rec.path, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	rec.dirty = false
+	return nil
+}
+
+func (rec *Recorder) find(r *http.Request, body []byte) (Interaction, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	r = rec.redactedRequest(r)
+
+	last := -1
+	for n, i := range rec.interactions {
+		if !rec.opts.Match(r, body, i.Request) {
+			continue
+		}
+		if !rec.used[n] {
+			rec.used[n] = true
+			return i, true
+		}
+		last = n
+	}
+
+	if last < 0 {
+		return Interaction{}, false
+	}
+	return rec.interactions[last], true
+}
+
+// redactedRequest returns a shallow copy of r whose URL is redacted like the
+// URLs in the cassette, so that matchers can compare them.
+func (rec *Recorder) redactedRequest(r *http.Request) *http.Request {
+	u, err := url.Parse(rec.opts.Redaction.RedactURL(r.URL))
+	if err != nil {
+		return r
+	}
+
+	r = r.WithContext(r.Context())
+	r.URL = u
+	return r
+}
+
+func (rec *Recorder) add(i Interaction) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.interactions = append(rec.interactions, i)
+	rec.used = append(rec.used, true)
+	rec.dirty = true
+}
+
+func (rec *Recorder) newInteraction(r *http.Request, body []byte, resp *http.Response, respBody []byte) Interaction {
+	policy := rec.opts.Redaction
+	scrub := func(body []byte, contentType string) []byte {
+		redacted, _, ok := policy.redactTextBody(body, contentType)
+		if !ok {
+			return nil
+		}
+		return redacted
+	}
+
+	i := Interaction{
+		Request: RecordedRequest{
+			Method: requestMethod(r),
+			URL:    policy.RedactURL(r.URL),
+			Header: policy.RedactHeader(r.Header),
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: policy.RedactHeader(resp.Header),
+		},
+	}
+	i.Request.Body, i.Request.BodyBase64 = encodeRecordedBody(scrub(body, r.Header.Get("Content-Type")))
+	i.Response.Body, i.Response.BodyBase64 = encodeRecordedBody(scrub(respBody, resp.Header.Get("Content-Type")))
+
+	if rec.opts.Scrub != nil {
+		rec.opts.Scrub(&i)
+	}
+
+	return i
+}
+
+func replayResponse(r *http.Request, recorded RecordedResponse) *http.Response {
+	return newResponse(r, recorded.Status, recorded.Header.Clone(), recorded.body())
+}
+
+// newResponse returns a response to r that has not been sent over the
+// network.
+func newResponse(r *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
+
+// A NoInteractionError is returned by a Recorder in ModeReplay if a request
+// does not match any recorded interaction.
+type NoInteractionError struct {
+	Method string
+	URL    string
+
+	// Closest is the recorded request that differs from the request in the
+	// fewest of method, URL and body, or nil if the cassette is empty.
+	Closest *RecordedRequest
+
+	// Differences lists how Closest differs from the request.
+	Differences []string
+}
+
+func (e *NoInteractionError) Error() string {
+	msg := fmt.Sprintf("no recorded interaction matches %s %s", e.Method, e.URL)
+	if e.Closest == nil {
+		return msg + " (the cassette is empty)"
+	}
+	return fmt.Sprintf("%s; closest recorded request is %s %s (differs in %s)",
+		msg, e.Closest.Method, e.Closest.URL, strings.Join(e.Differences, ", "))
+}
+
+func (rec *Recorder) noMatchError(r *http.Request, body []byte) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	e := &NoInteractionError{Method: requestMethod(r), URL: rec.opts.Redaction.RedactURL(r.URL)}
+	for n := range rec.interactions {
+		recorded := rec.interactions[n].Request
+
+		var diffs []string
+		if recorded.Method != e.Method {
+			diffs = append(diffs, "method")
+		}
+		if recorded.URL != e.URL {
+			diffs = append(diffs, "URL")
+		}
+		if !bytes.Equal(recorded.body(), body) {
+			diffs = append(diffs, "body")
+		}
+		if len(diffs) == 0 {
+			diffs = append(diffs, "headers")
+		}
+
+		if e.Closest == nil || len(diffs) < len(e.Differences) {
+			e.Closest, e.Differences = &recorded, diffs
+		}
+	}
+
+	return e
+}
+
+func requestMethod(r *http.Request) string {
+	if r.Method == "" {
+		return http.MethodGet
+	}
+	return r.Method
+}
@@ -0,0 +1,225 @@
+package cmhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 0xff, 0x00})
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"` + r.URL.Path[1:] + `","token":"secret-token"}`))
+		}
+	}))
+	defer server.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.jsonl")
+	opts := RecorderOptions{
+		Mode:      ModeRecord,
+		Redaction: RedactionPolicy{JSONPaths: []string{"token"}},
+	}
+
+	do := func(c Client, method, path string) error {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+
+	// Record
+	rec, err := NewRecorder(cassette, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Decorate(http.DefaultClient, rec.Record)
+	for _, path := range []string{"/a", "/b", "/image"} {
+		if err := do(client, "GET", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(content), "\n"); n != 3 {
+		t.Errorf("Cassette has %d lines, want 3:\n%s", n, content)
+	}
+	if strings.Contains(string(content), "secret") {
+		t.Errorf("Cassette contains secrets:\n%s", content)
+	}
+	if !strings.Contains(string(content), `"body_base64":"if8A"`) {
+		t.Errorf("Cassette does not contain base64 encoded binary body:\n%s", content)
+	}
+
+	// Replay
+	calls = 0
+	opts.Mode = ModeReplay
+	rec, err = NewRecorder(cassette, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = Decorate(http.DefaultClient, rec.Record)
+
+	req, _ := http.NewRequest("GET", server.URL+"/b", nil)
+	body := mustDoAndRead(t, client, req)
+	if string(body) != `{"id":"b","token":"REDACTED"}` {
+		t.Errorf("Replayed body = %s", body)
+	}
+	if calls != 0 {
+		t.Errorf("Server was called %d times during replay", calls)
+	}
+
+	err = do(client, "POST", "/a")
+	var noMatch *NoInteractionError
+	if !errors.As(err, &noMatch) {
+		t.Fatalf("Expected NoInteractionError, got %v", err)
+	}
+	if noMatch.Closest == nil || noMatch.Closest.URL != server.URL+"/a" || strings.Join(noMatch.Differences, ",") != "method" {
+		t.Errorf("Unexpected closest match: %v", err)
+	}
+	if !strings.Contains(err.Error(), "closest recorded request is GET "+server.URL+"/a (differs in method)") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+
+	// Record missing
+	opts.Mode = ModeRecordMissing
+	rec, err = NewRecorder(cassette, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = Decorate(http.DefaultClient, rec.Record)
+	for _, path := range []string{"/a", "/c"} {
+		if err := do(client, "GET", path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Server was called %d times, want 1", calls)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	interactions, err := loadCassette(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interactions) != 4 || interactions[3].Request.URL != server.URL+"/c" {
+		t.Errorf("Cassette has %d interactions after recording missing ones, want 4", len(interactions))
+	}
+}
+
+func TestRecorder_Matchers(t *testing.T) {
+	recorded := RecordedRequest{
+		Method: "POST",
+		URL:    "http://example.com/",
+		Header: http.Header{"X-Version": {"2"}},
+		Body:   "hello",
+	}
+
+	req, _ := http.NewRequest("POST", "http://example.com/", nil)
+	req.Header.Set("X-Version", "2")
+
+	if !MatchAll(MatchMethod, MatchURL, MatchHeaders("X-Version"), MatchBody)(req, []byte("hello"), recorded) {
+		t.Error("Request does not match")
+	}
+	if MatchBody(req, []byte("bye"), recorded) {
+		t.Error("Different body matches")
+	}
+
+	req.Header.Set("X-Version", "3")
+	if MatchHeaders("X-Version")(req, nil, recorded) {
+		t.Error("Different header matches")
+	}
+}
+
+func TestNewRecorder_MissingCassette(t *testing.T) {
+	cassette := filepath.Join(t.TempDir(), "missing.jsonl")
+
+	if _, err := NewRecorder(cassette, RecorderOptions{Mode: ModeReplay}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist in replay mode, got %v", err)
+	}
+	if _, err := NewRecorder(cassette, RecorderOptions{Mode: ModeRecordMissing}); err != nil {
+		t.Errorf("Unexpected error in record-missing mode: %v", err)
+	}
+}
+
+func TestRecorder_Redaction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		switch r.URL.Path {
+		case "/truncated":
+			w.Write([]byte(`{"token":"s3cr3t"`))
+		default:
+			w.Write([]byte(`{"token":"s3cr3t"}`))
+		}
+	}))
+	defer server.Close()
+
+	cassette := filepath.Join(t.TempDir(), "cassette.jsonl")
+	opts := RecorderOptions{
+		Mode:      ModeRecord,
+		Redaction: RedactionPolicy{JSONPaths: []string{"token"}},
+	}
+
+	rec, err := NewRecorder(cassette, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Decorate(http.DefaultClient, rec.Record)
+	for _, path := range []string{"/a?access_token=s3cr3t&page=1", "/truncated"} {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		mustDoAndRead(t, client, req)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "s3cr3t") {
+		t.Errorf("Cassette contains secrets:\n%s", content)
+	}
+	if !strings.Contains(string(content), `"body":"{\"token\":\"REDACTED\"}"`) {
+		t.Errorf("Cassette does not contain the redacted body:\n%s", content)
+	}
+
+	// Requests match the interactions with redacted URLs.
+	opts.Mode = ModeReplay
+	rec, err = NewRecorder(cassette, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = Decorate(ClientFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("unexpected request")
+	}), rec.Record)
+	req, _ := http.NewRequest("GET", server.URL+"/a?access_token=other&page=1", nil)
+	if body := mustDoAndRead(t, client, req); string(body) != `{"token":"REDACTED"}` {
+		t.Errorf("Replayed body = %q", body)
+	}
+}
@@ -0,0 +1,252 @@
+package cmhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultRedactedHeaders are the headers that a RedactionPolicy always
+// redacts.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// DefaultRedactedQueryParams are the query parameters that a RedactionPolicy
+// always redacts. They are matched case-insensitively.
+var DefaultRedactedQueryParams = []string{
+	"access_token",
+	"id_token",
+	"refresh_token",
+	"api_key",
+	"apikey",
+	"client_secret",
+	"password",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"X-Amz-Signature",
+}
+
+// A RedactionPolicy describes which secrets to remove from requests and
+// responses before they are logged or recorded.
+type RedactionPolicy struct {
+	// Headers are redacted in addition to DefaultRedactedHeaders.
+	Headers []string
+
+	// QueryParams are redacted in addition to DefaultRedactedQueryParams.
+	QueryParams []string
+
+	// JSONPaths are the JSON fields that are redacted, as dot-separated paths
+	// from the document root. The wildcard "*" matches any object key or
+	// array index, e.g. "items.*.token".
+	JSONPaths []string
+
+	// JSONFields redacts all JSON object fields whose key matches, at any
+	// depth.
+	JSONFields *regexp.Regexp
+
+	// Replacement replaces redacted values. If empty, "REDACTED" is used.
+	Replacement string
+}
+
+func (p RedactionPolicy) replacement() string {
+	if p.Replacement == "" {
+		return "REDACTED"
+	}
+	return p.Replacement
+}
+
+// RedactsHeader reports whether the header with the given name is redacted by p.
+func (p RedactionPolicy) RedactsHeader(name string) bool {
+	for _, h := range DefaultRedactedHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	for _, h := range p.Headers {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeader returns a copy of h with the values of all redacted headers
+// replaced.
+func (p RedactionPolicy) RedactHeader(h http.Header) http.Header {
+	redacted := make(http.Header, len(h))
+	for name, values := range h {
+		if !p.RedactsHeader(name) {
+			redacted[name] = append([]string(nil), values...)
+			continue
+		}
+		redacted[name] = make([]string, len(values))
+		for i := range values {
+			redacted[name][i] = p.replacement()
+		}
+	}
+	return redacted
+}
+
+// RedactsQueryParam reports whether the query parameter with the given name
+// is redacted by p.
+func (p RedactionPolicy) RedactsQueryParam(name string) bool {
+	for _, q := range DefaultRedactedQueryParams {
+		if strings.EqualFold(q, name) {
+			return true
+		}
+	}
+	for _, q := range p.QueryParams {
+		if strings.EqualFold(q, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactURL returns u as string with the password and the values of all
+// redacted query parameters replaced. The order of the query parameters is
+// preserved.
+func (p RedactionPolicy) RedactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Redacted()
+	}
+
+	redacted := *u
+	redacted.RawQuery = p.redactQuery(u.RawQuery)
+	return redacted.Redacted()
+}
+
+// redactQuery replaces the values of redacted parameters in the URL encoded
+// query or form body q, preserving the order of the parameters.
+func (p RedactionPolicy) redactQuery(q string) string {
+	pairs := strings.Split(q, "&")
+	for i, pair := range pairs {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil && p.RedactsQueryParam(unescaped) {
+			pairs[i] = name + "=" + url.QueryEscape(p.replacement())
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactsJSON reports whether p redacts anything in JSON documents.
+func (p RedactionPolicy) redactsJSON() bool {
+	return len(p.JSONPaths) > 0 || p.JSONFields != nil
+}
+
+// RedactJSON returns the JSON document doc with all redacted fields replaced.
+// Documents that are not valid JSON, including truncated ones, cause an error,
+// because they cannot be redacted reliably.
+func (p RedactionPolicy) RedactJSON(doc []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: unexpected data after top-level value")
+	}
+
+	if !p.redactsJSON() {
+		return doc, nil
+	}
+
+	paths := make([][]string, len(p.JSONPaths))
+	for i, path := range p.JSONPaths {
+		paths[i] = strings.Split(path, ".")
+	}
+
+	return json.Marshal(p.redactValue(v, nil, paths))
+}
+
+func (p RedactionPolicy) redactValue(v interface{}, path []string, paths [][]string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := append(path[:len(path):len(path)], key)
+			if (p.JSONFields != nil && p.JSONFields.MatchString(key)) || matchesAnyPath(childPath, paths) {
+				v[key] = p.replacement()
+			} else {
+				v[key] = p.redactValue(child, childPath, paths)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			childPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			if matchesAnyPath(childPath, paths) {
+				v[i] = p.replacement()
+			} else {
+				v[i] = p.redactValue(child, childPath, paths)
+			}
+		}
+	}
+	return v
+}
+
+func matchesAnyPath(path []string, patterns [][]string) bool {
+	for _, pattern := range patterns {
+		if len(pattern) != len(path) {
+			continue
+		}
+
+		matches := true
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != path[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// redactTextBody applies p to a text body with the given content type. Form
+// bodies are redacted like query strings. JSON bodies are redacted even if
+// they are not labeled as JSON. isJSON reports
+// whether body is a JSON document. If ok is false, body must not be used,
+// because p redacts JSON fields and body is or looks like a JSON document
+// that cannot be parsed, e.g. because it is truncated.
+func (p RedactionPolicy) redactTextBody(body []byte, contentType string) (redacted []byte, isJSON, ok bool) {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		return []byte(p.redactQuery(string(body))), false, true
+	}
+
+	labeled := isJSONContentType(contentType)
+	if !labeled && !p.redactsJSON() {
+		return body, false, true
+	}
+
+	doc, err := p.RedactJSON(body)
+	switch {
+	case err == nil:
+		return doc, true, true
+	case !p.redactsJSON():
+		return body, false, true
+	case labeled || looksLikeJSON(body):
+		return nil, false, false
+	default:
+		return body, false, true
+	}
+}
+
+// looksLikeJSON reports whether body starts like a JSON object or array.
+func looksLikeJSON(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
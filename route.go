@@ -0,0 +1,167 @@
+package cmhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// OtherRoute is the route of requests that have no route name, or that did
+// not match any pattern of a Routed client. It is used as label value in
+// metrics; logs and traces leave the route out instead.
+const OtherRoute = "other"
+
+type routeKey struct{}
+
+// WithRoute returns a copy of ctx that carries the route name of a request,
+// for instance "GET /v1/places/{id}". The route name is a low-cardinality
+// replacement for the request URL in metrics, logs and traces and is picked up
+// by InstrumentedWith, InstrumentedOTel, Logged, LoggedSlog and Traced.
+// Instrumented and InstrumentedWithOpts don't record route names, because
+// adding a label would change their existing metrics; use InstrumentedWith
+// with RouteLabel instead.
+func WithRoute(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, routeKey{}, name)
+}
+
+// RouteFromContext returns the route name stored in ctx by WithRoute, or
+// OtherRoute if there is none.
+func RouteFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(routeKey{}).(string); ok && name != "" {
+		return name
+	}
+	return OtherRoute
+}
+
+// routeFromContext is like RouteFromContext but returns the empty string if
+// ctx has no route name or if the route is OtherRoute.
+func routeFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(routeKey{}).(string)
+	if name == OtherRoute {
+		return ""
+	}
+	return name
+}
+
+// Routed sets the route name of all requests that do not have one yet (or
+// whose route is OtherRoute) to the first of the given patterns that matches
+// the request, or to OtherRoute if none matches. The route name is read by
+// the decorators that the Routed client wraps, so Routed must be applied after
+// them, e.g.
+//
+//	client := cmhttp.Decorate(http.DefaultClient,
+//		cmhttp.InstrumentedWith(nil, cmhttp.InstrumentOpts{RouteLabel: true}),
+//		cmhttp.Routed("GET /v1/places/{id}", "POST /v1/places"),
+//	)
+//
+// A pattern consists of an optional method and a path, separated by a space.
+// Path segments of the form {name} match any single non-empty path segment,
+// and a final segment of the form {name...} matches the remainder of the
+// path. Patterns without a method match requests with any method. Routed
+// panics if a pattern is invalid.
+func Routed(patterns ...string) Decorator {
+	routes := make([]routePattern, len(patterns))
+	for i, p := range patterns {
+		route, err := parseRoutePattern(p)
+		if err != nil {
+			panic(err)
+		}
+		routes[i] = route
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			if routeFromContext(r.Context()) != "" {
+				return c.Do(r)
+			}
+
+			name := OtherRoute
+			for _, route := range routes {
+				if route.matches(r) {
+					name = route.name
+					break
+				}
+			}
+
+			return c.Do(r.WithContext(WithRoute(r.Context(), name)))
+		})
+	}
+}
+
+type routePattern struct {
+	name     string
+	method   string
+	segments []string
+	rest     bool // the last segment matches the remainder of the path
+}
+
+func parseRoutePattern(pattern string) (routePattern, error) {
+	route := routePattern{name: pattern}
+
+	path := pattern
+	if method, p, ok := strings.Cut(pattern, " "); ok {
+		route.method, path = method, strings.TrimLeft(p, " ")
+	}
+	if !strings.HasPrefix(path, "/") {
+		return route, fmt.Errorf("invalid route pattern %q: path must start with a slash", pattern)
+	}
+
+	route.segments = strings.Split(path[1:], "/")
+	for i, seg := range route.segments {
+		if !strings.ContainsAny(seg, "{}") {
+			continue
+		}
+		if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") || len(seg) < 3 {
+			return route, fmt.Errorf("invalid route pattern %q: wildcards must be whole path segments", pattern)
+		}
+		if strings.HasSuffix(seg, "...}") {
+			if i != len(route.segments)-1 {
+				return route, fmt.Errorf("invalid route pattern %q: %s must be the last segment", pattern, seg)
+			}
+			route.rest = true
+		}
+	}
+
+	return route, nil
+}
+
+func (route routePattern) matches(r *http.Request) bool {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if route.method != "" && route.method != method {
+		return false
+	}
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	n := len(route.segments)
+	if route.rest {
+		n--
+		if len(segments) < n {
+			return false
+		}
+	} else if len(segments) != n {
+		return false
+	}
+
+	for i := 0; i < n; i++ {
+		seg := route.segments[i]
+		if strings.HasPrefix(seg, "{") {
+			if segments[i] == "" {
+				return false
+			}
+		} else if seg != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// routeTemplate returns the path template of a route name, i.e. the name
+// without a leading method.
+func routeTemplate(name, method string) string {
+	return strings.TrimPrefix(name, method+" ")
+}
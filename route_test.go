@@ -0,0 +1,137 @@
+package cmhttp
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRouted(t *testing.T) {
+	var route string
+	client := Routed(
+		"GET /v1/places/{id}",
+		"/v1/places/{id}/photos/{path...}",
+		"POST /v1/places",
+	)(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		route = RouteFromContext(r.Context())
+		return &http.Response{StatusCode: 200, Body: http.NoBody}, nil
+	}))
+
+	tests := []struct {
+		method, url string
+		want        string
+	}{
+		{"GET", "http://example.com/v1/places/42", "GET /v1/places/{id}"},
+		{"", "http://example.com/v1/places/42?q=1", "GET /v1/places/{id}"},
+		{"DELETE", "http://example.com/v1/places/42", OtherRoute},
+		{"GET", "http://example.com/v1/places/", OtherRoute},
+		{"GET", "http://example.com/v1/places/42/more", OtherRoute},
+		{"PUT", "http://example.com/v1/places/42/photos/a/b.jpg", "/v1/places/{id}/photos/{path...}"},
+		{"POST", "http://example.com/v1/places", "POST /v1/places"},
+		{"GET", "http://example.com/", OtherRoute},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, nil)
+		req.Method = tt.method
+		if _, err := client.Do(req); err != nil {
+			t.Fatal(err)
+		}
+		if route != tt.want {
+			t.Errorf("%s %s: route = %q, want %q", tt.method, tt.url, route, tt.want)
+		}
+	}
+
+	// Explicit route names take precedence.
+	req, _ := http.NewRequest("GET", "http://example.com/v1/places/42", nil)
+	req = req.WithContext(WithRoute(req.Context(), "get-place"))
+	client.Do(req)
+	if route != "get-place" {
+		t.Errorf("route = %q, want %q", route, "get-place")
+	}
+}
+
+func TestRouted_InvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{"v1/places", "GET /v1/{id}x", "/{path...}/more", "/{}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Routed(%q) did not panic", pattern)
+				}
+			}()
+			Routed(pattern)
+		}()
+	}
+}
+
+func TestRouted_Observability(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reg := prometheus.NewRegistry()
+	opts := InstrumentOpts{RouteLabel: true}
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	client := Decorate(http.DefaultClient,
+		InstrumentedWith(reg, opts),
+		LoggedSlog(logger, SlogOptions{}),
+		Traced(tp, propagation.TraceContext{}),
+		Routed("GET /v1/places/{id}"),
+	)
+
+	for _, path := range []string{"/v1/places/1", "/v1/places/2", "/v2"} {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL+path, nil)
+		mustDoAndRead(t, client, req)
+	}
+
+	m := newClientMetrics(reg, opts)
+	if n := testutil.ToFloat64(m.requests.WithLabelValues("get", "GET /v1/places/{id}", "200", "")); n != 2 {
+		t.Errorf("Got %v requests for route, want 2", n)
+	}
+	if n := testutil.ToFloat64(m.requests.WithLabelValues("get", OtherRoute, "200", "")); n != 1 {
+		t.Errorf("Got %v requests for other route, want 1", n)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, want 3", len(spans))
+	}
+	if spans[0].Name != "GET /v1/places/{id}" {
+		t.Errorf("Span name = %q, want %q", spans[0].Name, "GET /v1/places/{id}")
+	}
+	attrs := attribute.NewSet(spans[0].Attributes...)
+	if v, _ := attrs.Value("url.template"); v.AsString() != "/v1/places/{id}" {
+		t.Errorf("url.template = %q, want %q", v.AsString(), "/v1/places/{id}")
+	}
+	if spans[2].Name != "GET" {
+		t.Errorf("Span name = %q, want %q", spans[2].Name, "GET")
+	}
+	attrs = attribute.NewSet(spans[2].Attributes...)
+	if v, ok := attrs.Value("url.template"); ok {
+		t.Errorf("Span without route has url.template %q", v.AsString())
+	}
+
+	records := decodeLogRecords(t, &logs)
+	if len(records) != 3 {
+		t.Fatalf("Got %d log records, want 3", len(records))
+	}
+	if records[0]["route"] != "GET /v1/places/{id}" {
+		t.Errorf("Logged route = %v, want %q", records[0]["route"], "GET /v1/places/{id}")
+	}
+	if route, ok := records[2]["route"]; ok {
+		t.Errorf("Request without route was logged with route %v", route)
+	}
+}
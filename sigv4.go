@@ -0,0 +1,485 @@
+package cmhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Special values of the X-Amz-Content-Sha256 request header that select how
+// SigV4 treats the request payload.
+const (
+	// UnsignedPayload excludes the payload from the signature.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// StreamingPayload signs the payload in chunks while it is being sent,
+	// using the aws-chunked content encoding. The request's ContentLength
+	// must be known.
+	StreamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+const (
+	sigV4Algorithm      = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat     = "20060102T150405Z"
+	sigV4ChunkSize      = 64 << 10
+	sigV4EmptyStringSum = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// sigV4Now is the time source of SigV4. It is replaced in tests.
+var sigV4Now = time.Now
+
+// AWSCredentials are the credentials used to sign requests with SigV4.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // optional
+}
+
+// An AWSCredentialsProvider returns the AWSCredentials to sign a request with.
+// It is called for every request, so providers that fetch rotating
+// credentials should cache them. AWSCredentialsProviders must be safe for
+// concurrent use.
+type AWSCredentialsProvider interface {
+	Credentials(context.Context) (AWSCredentials, error)
+}
+
+// AWSCredentialsProviderFunc is a function type that implements the
+// AWSCredentialsProvider interface.
+type AWSCredentialsProviderFunc func(context.Context) (AWSCredentials, error)
+
+// Credentials calls f with the given context and returns its results
+// unchanged.
+func (f AWSCredentialsProviderFunc) Credentials(ctx context.Context) (AWSCredentials, error) {
+	return f(ctx)
+}
+
+// StaticAWSCredentials returns an AWSCredentialsProvider that always returns
+// the given credentials. sessionToken may be empty.
+func StaticAWSCredentials(accessKeyID, secretAccessKey, sessionToken string) AWSCredentialsProvider {
+	creds := AWSCredentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		SessionToken:    sessionToken,
+	}
+
+	return AWSCredentialsProviderFunc(func(context.Context) (AWSCredentials, error) {
+		return creds, nil
+	})
+}
+
+// EnvAWSCredentials returns an AWSCredentialsProvider that reads the
+// credentials from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables every time they are requested.
+func EnvAWSCredentials() AWSCredentialsProvider {
+	return AWSCredentialsProviderFunc(func(context.Context) (AWSCredentials, error) {
+		creds := AWSCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return AWSCredentials{}, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+		}
+		return creds, nil
+	})
+}
+
+// SigV4 signs all requests with AWS Signature Version 4 for the given region
+// and service (e.g. "s3"), using the credentials returned by credentials. It
+// sets the X-Amz-Date, Authorization and, if the credentials have a session
+// token, X-Amz-Security-Token headers.
+//
+// By default the payload is read into memory to compute its hash and is then
+// restored. This can be changed by setting the X-Amz-Content-Sha256 request
+// header beforehand:
+//
+//   - UnsignedPayload excludes the payload from the signature.
+//   - StreamingPayload signs the payload in 64 kB chunks while it is being
+//     sent, without buffering it. This requires Request.ContentLength to be
+//     known and changes the Content-Encoding and ContentLength of the request.
+//   - Any other value is assumed to be the hex encoded SHA-256 hash of the
+//     payload and is used as-is.
+//
+// The X-Amz-Content-Sha256 header is always sent to S3, and to other services
+// only if it has been set beforehand.
+func SigV4(credentials AWSCredentialsProvider, region, service string) Decorator {
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			creds, err := credentials.Credentials(r.Context())
+			if err != nil {
+				return nil, fmt.Errorf("obtaining AWS credentials: %w", err)
+			}
+
+			s := &sigV4Signer{
+				creds:   creds,
+				region:  region,
+				service: service,
+				time:    sigV4Now().UTC(),
+			}
+			if err := s.sign(r); err != nil {
+				return nil, err
+			}
+
+			return c.Do(r)
+		})
+	}
+}
+
+type sigV4Signer struct {
+	creds   AWSCredentials
+	region  string
+	service string
+	time    time.Time
+}
+
+func (s *sigV4Signer) sign(r *http.Request) error {
+	payloadHash, err := s.preparePayload(r)
+	if err != nil {
+		return err
+	}
+
+	r.Header.Del("Authorization")
+	r.Header.Set("X-Amz-Date", s.time.Format(sigV4TimeFormat))
+	if s.creds.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.creds.SessionToken)
+	}
+
+	headers, signedHeaders := s.canonicalHeaders(r)
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		s.canonicalURI(r.URL),
+		canonicalQuery(r.URL),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	key := s.signingKey()
+	signature := hex.EncodeToString(hmacSHA256(key, s.stringToSign(sha256Hex([]byte(canonicalRequest)))))
+
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.creds.AccessKeyID, s.scope(), signedHeaders, signature,
+	))
+
+	if payloadHash == StreamingPayload {
+		r.Body = newSigV4ChunkedBody(r.Body, s, key, signature)
+		if r.GetBody != nil {
+			getBody := r.GetBody
+			r.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return newSigV4ChunkedBody(body, s, key, signature), nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// preparePayload returns the payload hash for r and prepares r's body
+// accordingly.
+func (s *sigV4Signer) preparePayload(r *http.Request) (string, error) {
+	hash := r.Header.Get("X-Amz-Content-Sha256")
+
+	switch hash {
+	case UnsignedPayload:
+		return hash, nil
+
+	case StreamingPayload:
+		if r.ContentLength < 0 || (r.ContentLength == 0 && r.Body != nil && r.Body != http.NoBody) {
+			return "", fmt.Errorf("signing streaming payload: request content length must be known")
+		}
+
+		encoding := "aws-chunked"
+		if e := r.Header.Get("Content-Encoding"); e != "" {
+			encoding += "," + e
+		}
+		r.Header.Set("Content-Encoding", encoding)
+		r.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		r.ContentLength = sigV4ChunkedLength(r.ContentLength)
+		if r.Body == nil || r.Body == http.NoBody {
+			r.Body = io.NopCloser(strings.NewReader(""))
+		}
+		return hash, nil
+
+	case "":
+		body, err := readAndRestoreBody(r)
+		if err != nil {
+			return "", err
+		}
+
+		hash = sha256Hex(body)
+		if s.service == "s3" {
+			r.Header.Set("X-Amz-Content-Sha256", hash)
+		}
+		return hash, nil
+
+	default:
+		return hash, nil
+	}
+}
+
+// sigV4IgnoredHeaders are not signed because they may be changed by proxies
+// or by the transport.
+var sigV4IgnoredHeaders = map[string]bool{
+	"authorization":     true,
+	"user-agent":        true,
+	"x-amzn-trace-id":   true,
+	"connection":        true,
+	"expect":            true,
+	"transfer-encoding": true,
+}
+
+func (s *sigV4Signer) canonicalHeaders(r *http.Request) (headers, signedHeaders string) {
+	values := map[string]string{}
+	for name, vs := range r.Header {
+		name = strings.ToLower(name)
+		if sigV4IgnoredHeaders[name] {
+			continue
+		}
+
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[name] = strings.Join(trimmed, ",")
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	values["host"] = host
+
+	if r.Header.Get("X-Amz-Decoded-Content-Length") != "" {
+		values["content-length"] = strconv.FormatInt(r.ContentLength, 10)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, name := range names {
+		buf.WriteString(name)
+		buf.WriteByte(':')
+		buf.WriteString(values[name])
+		buf.WriteByte('\n')
+	}
+
+	return buf.String(), strings.Join(names, ";")
+}
+
+// canonicalURI returns the URI-encoded path of u. Each path segment is
+// decoded and then encoded once for S3 and twice for all other services, whose
+// paths are also normalized, as required by the specification.
+func (s *sigV4Signer) canonicalURI(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+
+	if s.service != "s3" {
+		cleaned := path.Clean(p)
+		if strings.HasSuffix(p, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		p = cleaned
+	}
+
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		if decoded, err := url.PathUnescape(seg); err == nil {
+			seg = decoded
+		}
+		seg = sigV4Escape(seg)
+		if s.service != "s3" {
+			seg = sigV4Escape(seg)
+		}
+		segments[i] = seg
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+
+	// Parameters are sorted by encoded name, then by encoded value. Sorting
+	// the joined "name=value" strings would put "key2=" before "key=".
+	var params [][2]string
+	for key, values := range u.Query() {
+		for _, v := range values {
+			params = append(params, [2]string{sigV4Escape(key), sigV4Escape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes all bytes of s except the unreserved characters
+// of RFC 3986.
+func sigV4Escape(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' ||
+			b == '-' || b == '_' || b == '.' || b == '~' {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+func (s *sigV4Signer) scope() string {
+	return strings.Join([]string{s.time.Format("20060102"), s.region, s.service, "aws4_request"}, "/")
+}
+
+func (s *sigV4Signer) stringToSign(canonicalRequestHash string) string {
+	return strings.Join([]string{
+		sigV4Algorithm,
+		s.time.Format(sigV4TimeFormat),
+		s.scope(),
+		canonicalRequestHash,
+	}, "\n")
+}
+
+func (s *sigV4Signer) signingKey() []byte {
+	key := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), s.time.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sigV4ChunkedLength returns the length of the aws-chunked encoding of a
+// payload with n bytes.
+func sigV4ChunkedLength(n int64) int64 {
+	chunkLen := func(size int64) int64 {
+		// hex(size) ";chunk-signature=" signature "\r\n" data "\r\n"
+		return int64(len(strconv.FormatInt(size, 16))) + 17 + 64 + 2 + size + 2
+	}
+
+	full := n / sigV4ChunkSize
+	length := full * chunkLen(sigV4ChunkSize)
+	if rest := n % sigV4ChunkSize; rest > 0 {
+		length += chunkLen(rest)
+	}
+
+	return length + chunkLen(0)
+}
+
+// sigV4ChunkedReader encodes a payload with the aws-chunked encoding and signs
+// each chunk.
+type sigV4ChunkedReader struct {
+	body    io.Reader
+	signer  *sigV4Signer
+	key     []byte
+	prevSig string
+
+	chunk []byte
+	buf   bytes.Buffer
+	done  bool
+}
+
+// newSigV4ChunkedBody returns a ReadCloser that reads the aws-chunked encoding
+// of body, starting with the given seed signature, and closes body.
+func newSigV4ChunkedBody(body io.ReadCloser, s *sigV4Signer, key []byte, seedSignature string) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		&sigV4ChunkedReader{
+			body:    body,
+			signer:  s,
+			key:     key,
+			prevSig: seedSignature,
+			chunk:   make([]byte, sigV4ChunkSize),
+		},
+		body,
+	}
+}
+
+func (cr *sigV4ChunkedReader) Read(p []byte) (int, error) {
+	if cr.buf.Len() == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		if err := cr.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	return cr.buf.Read(p)
+}
+
+func (cr *sigV4ChunkedReader) nextChunk() error {
+	n, err := io.ReadFull(cr.body, cr.chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	data := cr.chunk[:n]
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm + "-PAYLOAD",
+		cr.signer.time.Format(sigV4TimeFormat),
+		cr.signer.scope(),
+		cr.prevSig,
+		sigV4EmptyStringSum,
+		sha256Hex(data),
+	}, "\n")
+	cr.prevSig = hex.EncodeToString(hmacSHA256(cr.key, stringToSign))
+
+	fmt.Fprintf(&cr.buf, "%x;chunk-signature=%s\r\n", n, cr.prevSig)
+	cr.buf.Write(data)
+	cr.buf.WriteString("\r\n")
+
+	if n == 0 {
+		cr.done = true
+	} else if err != nil {
+		// The body is exhausted; the next call writes the final, empty chunk.
+		cr.body = strings.NewReader("")
+	}
+
+	return nil
+}
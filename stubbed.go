@@ -0,0 +1,143 @@
+package cmhttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A StubRoute is a canned response for requests that match Pattern.
+type StubRoute struct {
+	// Pattern is an optional method and a path pattern, like in Routed,
+	// e.g. "GET /v1/places/{id}".
+	Pattern string `json:"pattern" yaml:"pattern"`
+
+	// Status is the status code of the response. If zero, 200 is used.
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
+	// Body is the response body, unless BodyFile is set, in which case the
+	// body is read from that file for every request.
+	Body     string `json:"body,omitempty" yaml:"body,omitempty"`
+	BodyFile string `json:"body_file,omitempty" yaml:"body_file,omitempty"`
+}
+
+// A StubConfig is the content of a file loaded by LoadStubConfig.
+type StubConfig struct {
+	// FallThrough makes requests that match no route go to the real client.
+	FallThrough bool `json:"fallthrough" yaml:"fallthrough"`
+
+	Routes []StubRoute `json:"routes" yaml:"routes"`
+}
+
+// LoadStubConfig loads a StubConfig from a YAML file, if the name ends in
+// .yaml or .yml, or from a JSON file otherwise. Relative BodyFile paths are
+// resolved relative to the directory of the file.
+//
+// An example YAML file:
+//
+//	fallthrough: true
+//	routes:
+//	  - pattern: GET /v1/places/{id}
+//	    headers:
+//	      Content-Type: application/json
+//	    body_file: place.json
+//	  - pattern: DELETE /v1/places/{id}
+//	    status: 204
+func LoadStubConfig(name string) (StubConfig, error) {
+	var cfg StubConfig
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("loading stub config %s: %w", name, err)
+	}
+
+	for i, route := range cfg.Routes {
+		if route.BodyFile != "" && !filepath.IsAbs(route.BodyFile) {
+			cfg.Routes[i].BodyFile = filepath.Join(filepath.Dir(name), route.BodyFile)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Stubbed replies to requests with the first of the given routes that
+// matches, without ever opening a network connection. Requests that match no
+// route are made with the decorated client if fallThrough is true, and fail
+// otherwise. Stubbed panics if the pattern of a route is invalid.
+//
+// Stubbed is usually combined with LoadStubConfig:
+//
+//	cfg, err := cmhttp.LoadStubConfig("stubs.yaml")
+//	if err != nil {
+//		return err
+//	}
+//	client = cmhttp.Stubbed(cfg.Routes, cfg.FallThrough)(client)
+func Stubbed(routes []StubRoute, fallThrough bool) Decorator {
+	patterns := make([]routePattern, len(routes))
+	for i, route := range routes {
+		p, err := parseRoutePattern(route.Pattern)
+		if err != nil {
+			panic(err)
+		}
+		patterns[i] = p
+	}
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			for i, p := range patterns {
+				if p.matches(r) {
+					return stubResponse(r, routes[i])
+				}
+			}
+
+			if !fallThrough {
+				return nil, fmt.Errorf("no stub route matches %s %s", requestMethod(r), r.URL.Redacted())
+			}
+
+			return c.Do(r)
+		})
+	}
+}
+
+func stubResponse(r *http.Request, route StubRoute) (*http.Response, error) {
+	body := []byte(route.Body)
+	if route.BodyFile != "" {
+		var err error
+		if body, err = os.ReadFile(route.BodyFile); err != nil {
+			return nil, fmt.Errorf("reading stub body: %w", err)
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := make(http.Header, len(route.Headers))
+	for name, value := range route.Headers {
+		header.Set(name, value)
+	}
+
+	if r.Body != nil {
+		r.Body.Close()
+	}
+
+	return newResponse(r, status, header, body), nil
+}
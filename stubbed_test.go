@@ -0,0 +1,108 @@
+package cmhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestStubbed(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("real"))
+	}))
+	defer server.Close()
+
+	routes := []StubRoute{
+		{Pattern: "GET /v1/places/{id}", Headers: map[string]string{"Content-Type": "application/json"}, Body: `{"id":1}`},
+		{Pattern: "DELETE /v1/places/{id}", Status: 204},
+	}
+
+	client := Stubbed(routes, true)(http.DefaultClient)
+
+	tests := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		{"GET", "/v1/places/1", 200, `{"id":1}`},
+		{"DELETE", "/v1/places/1", 204, ""},
+		{"GET", "/v1/other", 200, "real"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := mustReadBody(t, resp)
+		if resp.StatusCode != tt.status || body != tt.body {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.path, resp.StatusCode, body, tt.status, tt.body)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Server was called %d times, want 1", calls)
+	}
+
+	client = Stubbed(routes, false)(http.DefaultClient)
+	req, _ := http.NewRequest("GET", server.URL+"/v1/other", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("Unmatched request did not fail")
+	}
+}
+
+func TestLoadStubConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "place.json"), []byte(`{"id":42}`))
+	writeFile(t, filepath.Join(dir, "stubs.yaml"), []byte(`
+fallthrough: true
+routes:
+  - pattern: GET /v1/places/{id}
+    headers:
+      Content-Type: application/json
+    body_file: place.json
+  - pattern: DELETE /v1/places/{id}
+    status: 204
+`))
+	writeFile(t, filepath.Join(dir, "stubs.json"), []byte(`{
+		"fallthrough": true,
+		"routes": [
+			{"pattern": "GET /v1/places/{id}", "headers": {"Content-Type": "application/json"}, "body_file": "place.json"},
+			{"pattern": "DELETE /v1/places/{id}", "status": 204}
+		]
+	}`))
+
+	for _, name := range []string{"stubs.yaml", "stubs.json"} {
+		cfg, err := LoadStubConfig(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cfg.FallThrough || len(cfg.Routes) != 2 || cfg.Routes[1].Status != 204 {
+			t.Fatalf("%s: unexpected config %+v", name, cfg)
+		}
+
+		client := Stubbed(cfg.Routes, cfg.FallThrough)(Null()(nil))
+		req, _ := http.NewRequest("GET", "http://example.com/v1/places/42", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := mustReadBody(t, resp); body != `{"id":42}` || resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s: got %q with headers %v", name, body, resp.Header)
+		}
+	}
+}
+
+func mustReadBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
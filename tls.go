@@ -1,10 +1,16 @@
 package cmhttp
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // ConfigureTLS reads the certificate bundle at rootCertsFilePath and
@@ -30,3 +36,245 @@ func MustConfigureTLS(t *http.Transport, rootCertsFilePath string) {
 		panic(err)
 	}
 }
+
+// TLSOptions configures ConfigureTLSWithOptions. All fields are optional.
+type TLSOptions struct {
+	// SystemRoots adds the system's root certificates to the certificates
+	// configured by RootCertsFile and RootCertsDir.
+	SystemRoots bool
+
+	// RootCertsFile is the path of a PEM encoded certificate bundle.
+	RootCertsFile string
+
+	// RootCertsDir is the path of a directory containing PEM encoded
+	// certificates. Subdirectories and files whose names start with a dot
+	// are ignored, as are files that don't contain any certificates.
+	RootCertsDir string
+
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+
+	// CipherSuites restricts the cipher suites used for TLS 1.0 to 1.2.
+	CipherSuites []uint16
+
+	// ServerName overrides the host name used to verify the server
+	// certificate and sent in the SNI extension.
+	ServerName string
+
+	// ClientCertificate is presented to servers that request a client
+	// certificate.
+	ClientCertificate *ClientCertificate
+
+	// Pins is a set of public key pins as returned by SPKIPin. If it is not
+	// empty, connections are rejected unless at least one certificate of the
+	// server's verified certificate chain matches one of the pins. If
+	// InsecureSkipVerify is set, only the server's own certificate is
+	// matched. Multiple pins allow rotating keys without downtime.
+	Pins []string
+
+	// PinReportOnly allows connections that violate Pins anyway. Violations
+	// are only reported to OnPinViolation.
+	PinReportOnly bool
+
+	// OnPinViolation is called for every connection that violates Pins, if
+	// it is not nil.
+	OnPinViolation func(PinViolation)
+
+	// ExpiryMonitor records the expiry times of the server certificates of
+	// all connections and of ClientCertificate.
+	ExpiryMonitor *CertExpiryMonitor
+}
+
+// PinViolation describes a connection whose certificate chain didn't match
+// any of the configured pins.
+type PinViolation struct {
+	ServerName string
+	Chain      []*x509.Certificate
+	Pins       []string
+}
+
+// SPKIPin returns the base64 encoded SHA-256 hash of the certificate's
+// SubjectPublicKeyInfo, for use in TLSOptions.Pins. This is the same format as
+// used by HTTP Public Key Pinning (RFC 7469), and can be computed with
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ConfigureTLSWithOptions configures the TLS settings of the given transport
+// according to opts. Unlike ConfigureTLS, it preserves existing settings that
+// are not covered by opts.
+//
+// If any root certificates are configured, the server certificates are
+// verified against them, in addition to any root certificates that the
+// transport has already been configured with. It is an error if RootCertsFile
+// or RootCertsDir doesn't contain a single valid certificate.
+func ConfigureTLSWithOptions(t *http.Transport, opts TLSOptions) error {
+	cfg := &tls.Config{}
+	if t.TLSClientConfig != nil {
+		cfg = t.TLSClientConfig.Clone()
+	}
+
+	if opts.SystemRoots || opts.RootCertsFile != "" || opts.RootCertsDir != "" {
+		pool, err := rootCertPool(cfg.RootCAs, opts)
+		if err != nil {
+			return err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.MinVersion != 0 {
+		cfg.MinVersion = opts.MinVersion
+	}
+	if opts.CipherSuites != nil {
+		cfg.CipherSuites = opts.CipherSuites
+	}
+	if opts.ServerName != "" {
+		cfg.ServerName = opts.ServerName
+	}
+	if opts.ClientCertificate != nil {
+		cfg.GetClientCertificate = opts.ClientCertificate.GetClientCertificate
+	}
+	if len(opts.Pins) > 0 {
+		cfg.VerifyConnection = chainVerifyConnection(cfg.VerifyConnection, verifyPins(opts))
+	}
+	if opts.ExpiryMonitor != nil {
+		cfg.VerifyConnection = chainVerifyConnection(cfg.VerifyConnection, opts.ExpiryMonitor.verifyConnection)
+		if opts.ClientCertificate != nil {
+			opts.ExpiryMonitor.ObserveClientCertificate(opts.ClientCertificate)
+		}
+	}
+
+	t.TLSClientConfig = cfg
+	return nil
+}
+
+// MustConfigureTLSWithOptions is the same as ConfigureTLSWithOptions, but
+// panics if there is an error.
+func MustConfigureTLSWithOptions(t *http.Transport, opts TLSOptions) {
+	if err := ConfigureTLSWithOptions(t, opts); err != nil {
+		panic(err)
+	}
+}
+
+func rootCertPool(existing *x509.CertPool, opts TLSOptions) (*x509.CertPool, error) {
+	var pool *x509.CertPool
+	switch {
+	case opts.SystemRoots && existing != nil:
+		// CertPools cannot be merged, so the existing certificates would be
+		// lost silently.
+		return nil, fmt.Errorf("cannot add system root certificates to already configured root certificates")
+	case opts.SystemRoots:
+		system, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("loading system root certificates: %w", err)
+		}
+		pool = system
+	case existing != nil:
+		pool = existing.Clone()
+	default:
+		pool = x509.NewCertPool()
+	}
+
+	if opts.RootCertsFile != "" {
+		buf, err := os.ReadFile(opts.RootCertsFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("%s does not contain any valid certificates", opts.RootCertsFile)
+		}
+	}
+
+	if opts.RootCertsDir != "" {
+		entries, err := os.ReadDir(opts.RootCertsDir)
+		if err != nil {
+			return nil, err
+		}
+
+		var found bool
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+
+			path := filepath.Join(opts.RootCertsDir, e.Name())
+			fi, err := os.Stat(path) // follows symlinks, unlike e.Info
+			if err != nil {
+				return nil, err
+			}
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+
+			buf, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if pool.AppendCertsFromPEM(buf) {
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s does not contain any valid certificates", opts.RootCertsDir)
+		}
+	}
+
+	return pool, nil
+}
+
+// chainVerifyConnection returns a tls.Config.VerifyConnection function that
+// calls first, unless it is nil, and then second.
+func chainVerifyConnection(first, second func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	if first == nil {
+		return second
+	}
+
+	return func(cs tls.ConnectionState) error {
+		if err := first(cs); err != nil {
+			return err
+		}
+		return second(cs)
+	}
+}
+
+func verifyPins(opts TLSOptions) func(tls.ConnectionState) error {
+	pins := make(map[string]bool, len(opts.Pins))
+	for _, p := range opts.Pins {
+		pins[p] = true
+	}
+
+	return func(cs tls.ConnectionState) error {
+		// VerifiedChains is empty if InsecureSkipVerify is set. The other
+		// certificates presented by the server are then unrelated to the
+		// connection as far as we know, so only the leaf may match.
+		chains := cs.VerifiedChains
+		if len(chains) == 0 && len(cs.PeerCertificates) > 0 {
+			chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+		}
+
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if pins[SPKIPin(cert)] {
+					return nil
+				}
+			}
+		}
+
+		if opts.OnPinViolation != nil {
+			opts.OnPinViolation(PinViolation{
+				ServerName: cs.ServerName,
+				Chain:      cs.PeerCertificates,
+				Pins:       opts.Pins,
+			})
+		}
+		if opts.PinReportOnly {
+			return nil
+		}
+
+		return fmt.Errorf("certificate chain of %s does not match any pinned public key", cs.ServerName)
+	}
+}
@@ -0,0 +1,165 @@
+package cmhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigureTLSWithOptions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	serverCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	otherCert, _ := testCertificate(t, "other", time.Now().Add(time.Hour))
+	writeFile(t, filepath.Join(dir, "server.pem"), serverCert)
+	writeFile(t, filepath.Join(dir, "other.pem"), otherCert)
+	writeFile(t, filepath.Join(dir, "README"), []byte("not a certificate"))
+
+	cases := []struct {
+		name string
+		opts TLSOptions
+	}{
+		{"file", TLSOptions{RootCertsFile: filepath.Join(dir, "server.pem")}},
+		{"dir", TLSOptions{RootCertsDir: dir}},
+		{"server name", TLSOptions{RootCertsDir: dir, ServerName: "example.com", MinVersion: tls.VersionTLS12}},
+	}
+
+	for _, c := range cases {
+		transport := &http.Transport{TLSClientConfig: &tls.Config{NextProtos: []string{"http/1.1"}}}
+		if err := ConfigureTLSWithOptions(transport, c.opts); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if len(transport.TLSClientConfig.NextProtos) != 1 {
+			t.Errorf("%s: existing TLS settings were not preserved", c.name)
+		}
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestConfigureTLSWithOptions_NoCertificates(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certificates here"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ConfigureTLSWithOptions(&http.Transport{}, TLSOptions{RootCertsFile: empty}); err == nil {
+		t.Error("Expected error for file without certificates")
+	}
+	if err := ConfigureTLSWithOptions(&http.Transport{}, TLSOptions{RootCertsDir: dir}); err == nil {
+		t.Error("Expected error for directory without certificates")
+	}
+}
+
+func TestConfigureTLSWithOptions_UnknownRoot(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "other.pem")
+	otherCert, _ := testCertificate(t, "other", time.Now().Add(time.Hour))
+	writeFile(t, file, otherCert)
+
+	transport := &http.Transport{}
+	if err := ConfigureTLSWithOptions(transport, TLSOptions{RootCertsFile: file}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		t.Error("Expected certificate verification error")
+	}
+}
+
+func TestConfigureTLSWithOptions_Pins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	roots := filepath.Join(t.TempDir(), "server.pem")
+	writeFile(t, roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	otherCert, _ := testCertificate(t, "other", time.Now().Add(time.Hour))
+	otherBlock, _ := pem.Decode(otherCert)
+	other, err := x509.ParseCertificate(otherBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		pins       []string
+		reportOnly bool
+		wantErr    bool
+		wantReport bool
+	}{
+		{"match", []string{SPKIPin(other), SPKIPin(server.Certificate())}, false, false, false},
+		{"mismatch", []string{SPKIPin(other)}, false, true, true},
+		{"report only", []string{SPKIPin(other)}, true, false, true},
+	}
+
+	for _, c := range cases {
+		var reported bool
+		transport := &http.Transport{}
+		err := ConfigureTLSWithOptions(transport, TLSOptions{
+			RootCertsFile:  roots,
+			Pins:           c.pins,
+			PinReportOnly:  c.reportOnly,
+			OnPinViolation: func(PinViolation) { reported = true },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if reported != c.wantReport {
+			t.Errorf("%s: violation reported = %t, want %t", c.name, reported, c.wantReport)
+		}
+	}
+}
+
+func TestVerifyPins_Unverified(t *testing.T) {
+	parse := func(commonName string) *x509.Certificate {
+		certPEM, _ := testCertificate(t, commonName, time.Now().Add(time.Hour))
+		block, _ := pem.Decode(certPEM)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	leaf, other := parse("leaf"), parse("other")
+
+	// Without verified chains, an attacker can append any certificate to the
+	// chain, so only the leaf may match.
+	cs := tls.ConnectionState{ServerName: "example.com", PeerCertificates: []*x509.Certificate{leaf, other}}
+
+	if err := verifyPins(TLSOptions{Pins: []string{SPKIPin(other)}})(cs); err == nil {
+		t.Error("Pin of an unverified intermediate was accepted")
+	}
+	if err := verifyPins(TLSOptions{Pins: []string{SPKIPin(leaf)}})(cs); err != nil {
+		t.Errorf("Pin of the leaf was rejected: %v", err)
+	}
+	if err := verifyPins(TLSOptions{Pins: []string{SPKIPin(leaf)}})(tls.ConnectionState{}); err == nil {
+		t.Error("Connection without certificates was accepted")
+	}
+}
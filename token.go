@@ -0,0 +1,140 @@
+package cmhttp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Token is a credential, such as a bearer token, with an optional expiry
+// time.
+type Token struct {
+	Value string
+
+	// Expiry is the time after which the token must not be used anymore. A
+	// zero Expiry means the token doesn't expire.
+	Expiry time.Time
+}
+
+// expiresWithin reports whether t expires within d from now.
+func (t Token) expiresWithin(d time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(d).After(t.Expiry)
+}
+
+// A TokenSource returns tokens, for instance to authenticate requests with the
+// Bearer decorator. TokenSources must be safe for concurrent use. A
+// TokenSource may return a token that is still usable together with an error,
+// e.g. if the token could not be refreshed.
+type TokenSource interface {
+	Token(context.Context) (Token, error)
+}
+
+// TokenSourceFunc is a function type that implements the TokenSource
+// interface.
+type TokenSourceFunc func(context.Context) (Token, error)
+
+// Token calls f with the given context and returns its results unchanged.
+func (f TokenSourceFunc) Token(ctx context.Context) (Token, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource that always returns the given token value.
+func StaticToken(value string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (Token, error) {
+		return Token{Value: value}, nil
+	})
+}
+
+// EnvToken returns a TokenSource that reads the token from the environment
+// variable with the given name every time a token is requested. It returns an
+// error if the variable is unset or empty.
+func EnvToken(name string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (Token, error) {
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == "" {
+			return Token{}, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return Token{Value: value}, nil
+	})
+}
+
+// FileToken returns a TokenSource that reads the token from the file at path,
+// ignoring leading and trailing white space. The file is read again whenever
+// its modification time or size changes, and additionally whenever interval
+// has passed since it has last been read, if interval is positive. This is
+// suitable for Kubernetes projected service account tokens, which are rotated
+// on disk.
+//
+// If the file cannot be read anymore after it has been read successfully, the
+// previously read token is returned together with the error.
+func FileToken(path string, interval time.Duration) TokenSource {
+	var (
+		mu       sync.Mutex
+		token    Token
+		modTime  time.Time
+		size     int64
+		lastRead time.Time
+	)
+
+	return TokenSourceFunc(func(context.Context) (Token, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			return token, err
+		}
+
+		changed := !fi.ModTime().Equal(modTime) || fi.Size() != size
+		expired := interval > 0 && time.Since(lastRead) >= interval
+		if token.Value != "" && !changed && !expired {
+			return token, nil
+		}
+
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return token, err
+		}
+
+		value := strings.TrimSpace(string(buf))
+		if value == "" {
+			return token, fmt.Errorf("token file %s is empty", path)
+		}
+
+		token = Token{Value: value}
+		modTime, size, lastRead = fi.ModTime(), fi.Size(), time.Now()
+
+		return token, nil
+	})
+}
+
+// CachedToken returns a TokenSource that caches the tokens returned by src
+// until leeway before they expire. Tokens without expiry time are cached
+// forever. Concurrent calls wait for a single call to src. Tokens that src
+// returns together with an error are passed on, but not cached.
+func CachedToken(src TokenSource, leeway time.Duration) TokenSource {
+	var (
+		mu    sync.Mutex
+		token Token
+	)
+
+	return TokenSourceFunc(func(ctx context.Context) (Token, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if token.Value != "" && !token.expiresWithin(leeway) {
+			return token, nil
+		}
+
+		t, err := src.Token(ctx)
+		if err != nil {
+			return t, err
+		}
+
+		token = t
+		return token, nil
+	})
+}
@@ -0,0 +1,119 @@
+package cmhttp
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/classmarkets/cmhttp"
+
+// Traced starts an OpenTelemetry client span for every request, following
+// the HTTP semantic conventions, and injects the span context into the
+// request headers with propagator. The span is named after the method and, if
+// the request has a route name (see WithRoute), its path template, which is
+// also recorded as url.template attribute. If tp is nil, the global
+// TracerProvider is used. If propagator is nil, the global TextMapPropagator
+// is used, which should usually be configured to inject the W3C traceparent
+// and tracestate headers.
+//
+// The span ends as soon as the response headers have been received or the
+// request failed. Responses with status code 400 or higher mark the span as
+// failed.
+//
+// If Traced decorates a FaultTolerant client, failed attempts that are
+// retried are recorded as "retry" span events. If FaultTolerant decorates a
+// Traced client instead, each attempt gets its own span with the
+// http.request.resend_count attribute.
+func Traced(tp trace.TracerProvider, propagator propagation.TextMapPropagator) Decorator {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
+	}
+	tracer := tp.Tracer(instrumentationName)
+
+	return func(c Client) Client {
+		return ClientFunc(func(r *http.Request) (*http.Response, error) {
+			method := r.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			name := method
+			attrs := requestSpanAttributes(r, method)
+			if route := routeFromContext(r.Context()); route != "" {
+				template := routeTemplate(route, method)
+				name += " " + template
+				attrs = append(attrs, semconv.URLTemplate(template))
+			}
+
+			ctx, span := tracer.Start(r.Context(), name,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...),
+			)
+			defer span.End()
+
+			ctx = withRetryObserver(ctx, func(attempt int, err error) {
+				span.AddEvent("retry", trace.WithAttributes(
+					semconv.HTTPRequestResendCount(attempt),
+					semconv.ErrorTypeKey.String(errorClass(err)),
+					attribute.String("exception.message", err.Error()),
+				))
+			})
+
+			r = r.WithContext(ctx)
+			propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+			resp, err := c.Do(r)
+			if err != nil {
+				span.SetAttributes(semconv.ErrorTypeKey.String(errorClass(err)))
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				return resp, err
+			}
+
+			span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+			if resp.StatusCode >= 400 {
+				span.SetAttributes(semconv.ErrorTypeKey.String(strconv.Itoa(resp.StatusCode)))
+				span.SetStatus(codes.Error, "")
+			}
+
+			return resp, err
+		})
+	}
+}
+
+func requestSpanAttributes(r *http.Request, method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(method),
+		semconv.URLFull(r.URL.Redacted()),
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		attrs = append(attrs, semconv.ServerAddress(h))
+		if port, err := strconv.Atoi(p); err == nil {
+			attrs = append(attrs, semconv.ServerPort(port))
+		}
+	} else if host != "" {
+		attrs = append(attrs, semconv.ServerAddress(host))
+	}
+
+	if n := Attempt(r.Context()); n > 1 {
+		attrs = append(attrs, semconv.HTTPRequestResendCount(n-1))
+	}
+
+	return attrs
+}
@@ -0,0 +1,143 @@
+package cmhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraced(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := Traced(tp, propagation.TraceContext{})(http.DefaultClient)
+
+	req, _ := http.NewRequest("GET", server.URL+"/places?q=berlin", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name != "GET" || span.SpanKind != trace.SpanKindClient {
+		t.Errorf("Unexpected span name %q or kind %s", span.Name, span.SpanKind)
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("Span status = %s, want %s", span.Status.Code, codes.Error)
+	}
+
+	want := "00-" + span.SpanContext.TraceID().String() + "-" + span.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("Server received traceparent %q, want %q", traceparent, want)
+	}
+
+	attrs := attribute.NewSet(span.Attributes...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		"http.request.method":       attribute.StringValue("GET"),
+		"url.full":                  attribute.StringValue(server.URL + "/places?q=berlin"),
+		"server.address":            attribute.StringValue("127.0.0.1"),
+		"http.response.status_code": attribute.IntValue(404),
+		"error.type":                attribute.StringValue("404"),
+	} {
+		if got, _ := attrs.Value(key); got != want {
+			t.Errorf("Span attribute %s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+}
+
+func TestTraced_Retries(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var calls int
+	failing := ClientFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	client := Decorate(failing,
+		FaultTolerant(3, 0),
+		Traced(tp, propagation.TraceContext{}),
+	)
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Got %d spans, want 1", len(spans))
+	}
+	if n := len(spans[0].Events); n != 2 {
+		t.Errorf("Span has %d events, want 2 retries", n)
+	}
+
+	// With FaultTolerant outside of Traced, every attempt has its own span.
+	exporter.Reset()
+	calls = 0
+	client = Decorate(failing,
+		Traced(tp, propagation.TraceContext{}),
+		FaultTolerant(3, 0),
+	)
+
+	req, _ = http.NewRequest("GET", "http://example.com", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	spans = exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Got %d spans, want 3", len(spans))
+	}
+	last := attribute.NewSet(spans[2].Attributes...)
+	if got, _ := last.Value("http.request.resend_count"); got.AsInt64() != 2 {
+		t.Errorf("Last span has resend count %d, want 2", got.AsInt64())
+	}
+}
+
+func TestTraced_ErrorType(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	client := Traced(tp, propagation.TraceContext{})(ClientFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, &url.Error{Op: "Get", URL: r.URL.String(), Err: context.Canceled}
+	}))
+
+	req, _ := http.NewRequest("GET", "http://example.com", nil)
+	if _, err := client.Do(req); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	// The error type matches the error class of InstrumentedWith.
+	attrs := attribute.NewSet(exporter.GetSpans()[0].Attributes...)
+	if got, _ := attrs.Value("error.type"); got.AsString() != "canceled" {
+		t.Errorf("error.type = %q, want %q", got.AsString(), "canceled")
+	}
+}